@@ -458,7 +458,7 @@ func (c *RaftCluster) BuryStore(storeID uint64, force bool) error { // revive:di
 		if !force {
 			return errors.New("store is still up, please remove store gracefully")
 		}
-		log.Warnf("forcedly bury store %v", store)
+		log.WithFields(store.LogFields()).Warn("forcedly bury store")
 	}
 
 	newStore := store.Clone(core.SetStoreState(metapb.StoreState_Tombstone))
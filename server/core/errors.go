@@ -26,16 +26,29 @@ import (
 var (
 	// Parent for other errors
 	storeStateCode = errcode.StateCode.Child("state.store")
+	storeInputCode = errcode.InvalidInputCode.Child("input.store")
 
 	// StoreBlockedCode is an error due to requesting an operation that is invalid due to a store being in a blocked state
 	StoreBlockedCode = storeStateCode.Child("state.store.blocked")
 
+	// StoreDisconnectedCode is an error due to requesting an operation on a store which stops sending heartbeats.
+	StoreDisconnectedCode = storeStateCode.Child("state.store.disconnected")
+
+	// StoreLowSpaceCode is an error due to requesting an operation on a store which is lack of space.
+	StoreLowSpaceCode = storeStateCode.Child("state.store.low_space")
+
 	// StoreTombstonedCode is an invalid operation was attempted on a store which is in a removed state.
 	StoreTombstonedCode = storeStateCode.Child("state.store.tombstoned").SetHTTP(http.StatusGone)
+
+	// StoreLabelInvalidCode is an error due to updating a store with invalid labels.
+	StoreLabelInvalidCode = storeInputCode.Child("input.store.label")
 )
 
-var _ errcode.ErrorCode = (*StoreTombstonedErr)(nil) // assert implements interface
-var _ errcode.ErrorCode = (*StoreBlockedErr)(nil)    // assert implements interface
+var _ errcode.ErrorCode = (*StoreTombstonedErr)(nil)   // assert implements interface
+var _ errcode.ErrorCode = (*StoreBlockedErr)(nil)      // assert implements interface
+var _ errcode.ErrorCode = (*StoreDisconnectedErr)(nil) // assert implements interface
+var _ errcode.ErrorCode = (*StoreLowSpaceErr)(nil)     // assert implements interface
+var _ errcode.ErrorCode = (*StoreLabelInvalidErr)(nil) // assert implements interface
 
 // StoreErr can be newtyped or embedded in your own error
 type StoreErr struct {
@@ -61,3 +74,37 @@ func (e StoreBlockedErr) Error() string {
 
 // Code returns StoreBlockedCode
 func (e StoreBlockedErr) Code() errcode.Code { return StoreBlockedCode }
+
+// StoreDisconnectedErr has a Code() of StoreDisconnectedCode
+type StoreDisconnectedErr StoreErr
+
+func (e StoreDisconnectedErr) Error() string {
+	return fmt.Sprintf("store %v is disconnected", e.StoreID)
+}
+
+// Code returns StoreDisconnectedCode
+func (e StoreDisconnectedErr) Code() errcode.Code { return StoreDisconnectedCode }
+
+// StoreLowSpaceErr has a Code() of StoreLowSpaceCode
+type StoreLowSpaceErr StoreErr
+
+func (e StoreLowSpaceErr) Error() string {
+	return fmt.Sprintf("store %v is lack of space", e.StoreID)
+}
+
+// Code returns StoreLowSpaceCode
+func (e StoreLowSpaceErr) Code() errcode.Code { return StoreLowSpaceCode }
+
+// StoreLabelInvalidErr has a Code() of StoreLabelInvalidCode
+type StoreLabelInvalidErr struct {
+	StoreID uint64 `json:"storeId"`
+	Key     string `json:"key"`
+	Reason  string `json:"reason"`
+}
+
+func (e StoreLabelInvalidErr) Error() string {
+	return fmt.Sprintf("invalid label %q for store %v: %s", e.Key, e.StoreID, e.Reason)
+}
+
+// Code returns StoreLabelInvalidCode
+func (e StoreLabelInvalidErr) Code() errcode.Code { return StoreLabelInvalidCode }
@@ -43,3 +43,24 @@ func (k ResourceKind) String() string {
 		return "unknown"
 	}
 }
+
+// PeerOperationKind distinguishes different kinds of peer operations on a store.
+type PeerOperationKind int
+
+const (
+	// AddPeerKind indicates adding a peer to the store
+	AddPeerKind PeerOperationKind = iota
+	// RemovePeerKind indicates removing a peer from the store
+	RemovePeerKind
+)
+
+func (k PeerOperationKind) String() string {
+	switch k {
+	case AddPeerKind:
+		return "add-peer"
+	case RemovePeerKind:
+		return "remove-peer"
+	default:
+		return "unknown"
+	}
+}
@@ -14,42 +14,301 @@
 package core
 
 import (
+	"sort"
+	"time"
+
 	"github.com/montanaflynn/stats"
 )
 
+// AggregationMode is the way to summarize the records of RollingStats.
+type AggregationMode int
+
+// Aggregation modes of RollingStats.
+const (
+	MedianMode AggregationMode = iota
+	MeanMode
+)
+
 // RollingStats provides rolling statistics with specified window size.
 // There are window size records for calculating.
 type RollingStats struct {
 	records []float64
 	size    int
 	count   int
+	mode    AggregationMode
+	// times are the time when the records are added, records older than
+	// expiry are excluded when reading. Zero expiry means never expire.
+	times  []time.Time
+	expiry time.Duration
 }
 
 // NewRollingStats returns a RollingStats.
 func NewRollingStats(size int) *RollingStats {
+	return NewRollingStatsWithMode(size, MedianMode)
+}
+
+// NewRollingStatsWithMode returns a RollingStats summarized by mode.
+func NewRollingStatsWithMode(size int, mode AggregationMode) *RollingStats {
 	return &RollingStats{
 		records: make([]float64, size),
 		size:    size,
+		mode:    mode,
+	}
+}
+
+// NewRollingStatsWithExpiry returns a RollingStats whose records expire after
+// expiry.
+func NewRollingStatsWithExpiry(size int, mode AggregationMode, expiry time.Duration) *RollingStats {
+	r := NewRollingStatsWithMode(size, mode)
+	r.setExpiry(expiry)
+	return r
+}
+
+// setExpiry makes the records expire after expiry, the existing records are
+// regarded as just added.
+func (r *RollingStats) setExpiry(expiry time.Duration) {
+	if r.times == nil {
+		r.times = make([]time.Time, r.size)
+		ts := now()
+		for i := 0; i < r.count && i < r.size; i++ {
+			r.times[i] = ts
+		}
 	}
+	r.expiry = expiry
 }
 
 // Add adds an element.
 func (r *RollingStats) Add(n float64) {
+	if r.times != nil {
+		r.times[r.count%r.size] = now()
+	}
 	r.records[r.count%r.size] = n
 	r.count++
 }
 
+func (r *RollingStats) clone() *RollingStats {
+	records := make([]float64, len(r.records))
+	copy(records, r.records)
+	stats := &RollingStats{
+		records: records,
+		size:    r.size,
+		count:   r.count,
+		mode:    r.mode,
+		expiry:  r.expiry,
+	}
+	if r.times != nil {
+		stats.times = make([]time.Time, len(r.times))
+		copy(stats.times, r.times)
+	}
+	return stats
+}
+
+// Samples returns a copy of the unexpired records from the oldest to the
+// newest.
+func (r *RollingStats) Samples() []float64 {
+	n, start := r.count, 0
+	if r.count >= r.size {
+		n, start = r.size, r.count%r.size
+	}
+	samples := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		idx := (start + i) % r.size
+		if !r.expired(idx) {
+			samples = append(samples, r.records[idx])
+		}
+	}
+	return samples
+}
+
+// Len returns the number of unexpired records in the window.
+func (r *RollingStats) Len() int {
+	return len(r.validRecords())
+}
+
+func (r *RollingStats) expired(idx int) bool {
+	return r.expiry > 0 && since(r.times[idx]) > r.expiry
+}
+
+// validRecords returns the records in the window which are not expired.
+func (r *RollingStats) validRecords() []float64 {
+	records := r.records
+	if r.count < r.size {
+		records = r.records[:r.count]
+	}
+	if r.expiry <= 0 {
+		return records
+	}
+	valid := make([]float64, 0, len(records))
+	for i, n := range records {
+		if !r.expired(i) {
+			valid = append(valid, n)
+		}
+	}
+	return valid
+}
+
+// Reset clears all the records.
+func (r *RollingStats) Reset() {
+	for i := range r.records {
+		r.records[i] = 0
+	}
+	for i := range r.times {
+		r.times[i] = time.Time{}
+	}
+	r.count = 0
+}
+
+// Value returns the summary of the records by the aggregation mode.
+func (r *RollingStats) Value() float64 {
+	if r.mode == MeanMode {
+		return r.Mean()
+	}
+	return r.Median()
+}
+
+// Mean returns the mean of the records.
+func (r *RollingStats) Mean() float64 {
+	records := r.validRecords()
+	if len(records) == 0 {
+		return 0
+	}
+	mean, _ := stats.Mean(records)
+	return mean
+}
+
 // Median returns the median of the records.
 // it can be used to filter noise.
 // References: https://en.wikipedia.org/wiki/Median_filter.
 func (r *RollingStats) Median() float64 {
-	if r.count == 0 {
+	records := r.validRecords()
+	if len(records) == 0 {
 		return 0
 	}
-	records := r.records
-	if r.count < r.size {
-		records = r.records[:r.count]
-	}
 	median, _ := stats.Median(records)
 	return median
 }
+
+// Percentile returns the nearest-rank percentile of the records, percent is in
+// (0, 100].
+func (r *RollingStats) Percentile(percent float64) float64 {
+	if r.count == 0 {
+		return 0
+	}
+	records := r.validRecords()
+	if len(records) == 0 {
+		return 0
+	}
+	percentile, _ := stats.PercentileNearestRank(records, percent)
+	return percentile
+}
+
+// QuantileEstimator estimates a percentile of a stream with the P2 algorithm,
+// which costs O(1) per sample and keeps five markers instead of all the
+// samples. Unlike RollingStats, it has no window: the estimate covers all the
+// samples added since the last Reset.
+// References: https://www.cse.wustl.edu/~jain/papers/ftp/psqr.pdf.
+type QuantileEstimator struct {
+	p     float64
+	count int
+	// heights of the markers.
+	q [5]float64
+	// actual positions of the markers.
+	n [5]float64
+	// desired positions of the markers and their increments.
+	np [5]float64
+	dn [5]float64
+}
+
+// NewQuantileEstimator returns a QuantileEstimator of the given percentile,
+// percent is in (0, 100].
+func NewQuantileEstimator(percent float64) *QuantileEstimator {
+	return newQuantileEstimator(percent / 100)
+}
+
+func newQuantileEstimator(p float64) *QuantileEstimator {
+	return &QuantileEstimator{
+		p:  p,
+		n:  [5]float64{1, 2, 3, 4, 5},
+		np: [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5},
+		dn: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// Add adds a sample.
+func (e *QuantileEstimator) Add(x float64) {
+	if e.count < len(e.q) {
+		e.q[e.count] = x
+		e.count++
+		if e.count == len(e.q) {
+			sort.Float64s(e.q[:])
+		}
+		return
+	}
+	e.count++
+
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3 && x >= e.q[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < len(e.n); i++ {
+		e.n[i]++
+	}
+	for i := range e.np {
+		e.np[i] += e.dn[i]
+	}
+
+	// Adjust the heights of the middle markers if they are off their
+	// desired positions.
+	for i := 1; i < 4; i++ {
+		d := e.np[i] - e.n[i]
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			sign := 1.0
+			if d < 0 {
+				sign = -1.0
+			}
+			q := e.parabolic(i, sign)
+			if e.q[i-1] < q && q < e.q[i+1] {
+				e.q[i] = q
+			} else {
+				e.q[i] = e.linear(i, sign)
+			}
+			e.n[i] += sign
+		}
+	}
+}
+
+func (e *QuantileEstimator) parabolic(i int, d float64) float64 {
+	return e.q[i] + d/(e.n[i+1]-e.n[i-1])*
+		((e.n[i]-e.n[i-1]+d)*(e.q[i+1]-e.q[i])/(e.n[i+1]-e.n[i])+
+			(e.n[i+1]-e.n[i]-d)*(e.q[i]-e.q[i-1])/(e.n[i]-e.n[i-1]))
+}
+
+func (e *QuantileEstimator) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.q[i] + d*(e.q[j]-e.q[i])/(e.n[j]-e.n[i])
+}
+
+// Reset clears the samples.
+func (e *QuantileEstimator) Reset() {
+	*e = *newQuantileEstimator(e.p)
+}
+
+// Value returns the estimated percentile.
+func (e *QuantileEstimator) Value() float64 {
+	if e.count == 0 {
+		return 0
+	}
+	if e.count < len(e.q) {
+		// Not enough samples for the markers, use the exact percentile.
+		percentile, _ := stats.PercentileNearestRank(e.q[:e.count], e.p*100)
+		return percentile
+	}
+	return e.q[2]
+}
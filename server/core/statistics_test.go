@@ -14,6 +14,10 @@
 package core
 
 import (
+	"math"
+	"math/rand"
+	"time"
+
 	. "github.com/pingcap/check"
 )
 
@@ -30,3 +34,114 @@ func (t *testRollingStats) TestRollingMedian(c *C) {
 		c.Assert(stats.Median(), Equals, expected[i])
 	}
 }
+
+func (t *testRollingStats) TestRollingReset(c *C) {
+	stats := NewRollingStats(3)
+	stats.Add(5)
+	stats.Add(7)
+	stats.Reset()
+	c.Assert(stats.Median(), Equals, 0.0)
+	stats.Add(3)
+	c.Assert(stats.Median(), Equals, 3.0)
+}
+
+func (t *testRollingStats) TestRollingMean(c *C) {
+	stats := NewRollingStatsWithMode(3, MeanMode)
+	c.Assert(stats.Value(), Equals, 0.0)
+	for _, n := range []float64{1, 2, 900} {
+		stats.Add(n)
+	}
+	c.Assert(stats.Mean(), Equals, 301.0)
+	c.Assert(stats.Median(), Equals, 2.0)
+	c.Assert(stats.Value(), Equals, 301.0)
+	c.Assert(NewRollingStats(3).Value(), Equals, 0.0)
+}
+
+func (t *testRollingStats) TestRollingPercentile(c *C) {
+	stats := NewRollingStats(5)
+	c.Assert(stats.Percentile(90), Equals, 0.0)
+	for _, n := range []float64{5, 1, 4, 2, 3} {
+		stats.Add(n)
+	}
+	c.Assert(stats.Percentile(100), Equals, 5.0)
+	c.Assert(stats.Percentile(60), Equals, 3.0)
+
+	// The evicted records are forgotten after the distribution shifts.
+	const size = 100
+	r := rand.New(rand.NewSource(1))
+	stats = NewRollingStats(size)
+	for i := 0; i < size; i++ {
+		stats.Add(r.Float64() * 1000)
+	}
+	for i := 0; i < size/2; i++ {
+		stats.Add(1000 + r.Float64()*1000)
+	}
+	c.Assert(stats.Percentile(25) < 1000, IsTrue)
+	c.Assert(stats.Percentile(75) >= 1000, IsTrue)
+	for i := 0; i < size/2; i++ {
+		stats.Add(1000 + r.Float64()*1000)
+	}
+	c.Assert(stats.Percentile(1) >= 1000, IsTrue)
+}
+
+func (t *testRollingStats) TestQuantileEstimator(c *C) {
+	const size = 10000
+	r := rand.New(rand.NewSource(1))
+	for _, percent := range []float64{50, 90, 99} {
+		exact := NewRollingStats(size)
+		estimator := NewQuantileEstimator(percent)
+		for i := 0; i < size; i++ {
+			n := r.Float64() * 1000
+			exact.Add(n)
+			estimator.Add(n)
+		}
+		// The estimate should be within 1% of the value range.
+		c.Assert(math.Abs(estimator.Value()-exact.Percentile(percent)) < 10, IsTrue)
+	}
+
+	estimator := NewQuantileEstimator(50)
+	c.Assert(estimator.Value(), Equals, 0.0)
+	for _, n := range []float64{1, 3, 2} {
+		estimator.Add(n)
+	}
+	c.Assert(estimator.Value(), Equals, 2.0)
+	estimator.Reset()
+	c.Assert(estimator.Value(), Equals, 0.0)
+}
+
+func (t *testRollingStats) TestRollingSamples(c *C) {
+	stats := NewRollingStats(3)
+	c.Assert(stats.Samples(), HasLen, 0)
+	stats.Add(1)
+	stats.Add(2)
+	c.Assert(stats.Samples(), DeepEquals, []float64{1, 2})
+	stats.Add(3)
+	stats.Add(4)
+	samples := stats.Samples()
+	c.Assert(samples, DeepEquals, []float64{2, 3, 4})
+	samples[0] = 100
+	c.Assert(stats.Samples(), DeepEquals, []float64{2, 3, 4})
+}
+
+func (t *testRollingStats) TestRollingExpiry(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stats := NewRollingStatsWithExpiry(5, MedianMode, time.Minute)
+	stats.Add(1)
+	clock.advance(30 * time.Second)
+	stats.Add(3)
+	stats.Add(5)
+	c.Assert(stats.Median(), Equals, 3.0)
+
+	clock.advance(40 * time.Second)
+	c.Assert(stats.Samples(), DeepEquals, []float64{3, 5})
+	c.Assert(stats.Median(), Equals, 4.0)
+	c.Assert(stats.Mean(), Equals, 4.0)
+
+	clock.advance(time.Minute)
+	c.Assert(stats.Median(), Equals, 0.0)
+	c.Assert(stats.Percentile(50), Equals, 0.0)
+	c.Assert(stats.Samples(), HasLen, 0)
+	c.Assert(NewRollingStats(3).Percentile(50), Equals, 0.0)
+}
@@ -15,14 +15,21 @@ package core
 
 import (
 	"fmt"
+	"hash/fnv"
 	"math"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coreos/go-semver/semver"
+	"github.com/montanaflynn/stats"
 	"github.com/pingcap/errcode"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -31,7 +38,13 @@ type StoreInfo struct {
 	meta  *metapb.Store
 	stats *pdpb.StoreStats
 	// Blocked means that the store is blocked from balance.
-	blocked           bool
+	blocked bool
+	// ReadOnly means that the store keeps serving reads but accepts no new
+	// peers. Unlike Blocked, it does not stop moving peers out of the store.
+	readOnly bool
+	// Draining means that the store accepts no new leaders, but keeps the
+	// existing ones until they are migrated. Regions are not affected.
+	draining          bool
 	leaderCount       int
 	regionCount       int
 	leaderSize        int64
@@ -41,16 +54,109 @@ type StoreInfo struct {
 	leaderWeight      float64
 	regionWeight      float64
 	rollingStoreStats *RollingStoreStats
+	applyLatency      time.Duration
+	commitLatency     time.Duration
+	addPeerLimit      int
+	removePeerLimit   int
+	// prevCapacity is the capacity reported by the previous statistics.
+	prevCapacity uint64
+	// Frozen means that the statistics and counts of the store are pinned
+	// for debugging.
+	frozen bool
+	// heartbeatIntervals are the intervals between the last few heartbeats.
+	heartbeatIntervals []time.Duration
+	// blockCount is the number of times the store is blocked, which decays
+	// over time since lastBlockTime.
+	blockCount    int
+	lastBlockTime time.Time
+	// reservedRegionSize is the region size reserved by in-flight operations
+	// but not yet reflected in heartbeats. It is shared by the clones.
+	reservedRegionSize *int64
+	// scoreCache caches the last region score, it is not copied by Clone so
+	// that it is always fresh.
+	scoreCache *regionScoreCache
+	// labelsFingerprint is the hash of the labels, it is computed when the
+	// store is created or its labels are set.
+	labelsFingerprint uint64
+	// pauseUntil is the deadline before which scheduling to the store is
+	// paused.
+	pauseUntil time.Time
+	// upTransitionTime is the time when the store becomes Up from another
+	// state, it is used to warm up the region weight.
+	upTransitionTime time.Time
+	// tombstoneTime is the time when the store becomes Tombstone.
+	tombstoneTime time.Time
+	// events are the recent events of the store, it is shared by the clones.
+	events *storeEventHistory
+}
+
+type regionScoreCache struct {
+	sync.Mutex
+	valid          bool
+	version        uint64
+	highSpaceRatio float64
+	lowSpaceRatio  float64
+	score          float64
+}
+
+// Kinds of the store events recorded automatically.
+const (
+	StoreEventStateChange = "state_change"
+	StoreEventBlock       = "block"
+	StoreEventUnblock     = "unblock"
+)
+
+// storeEventHistorySize is the number of the recent events kept per store.
+const storeEventHistorySize = 16
+
+// StoreEvent is an event in the timeline of a store.
+type StoreEvent struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// storeEventHistory is a ring buffer of the recent store events.
+type storeEventHistory struct {
+	sync.Mutex
+	events [storeEventHistorySize]StoreEvent
+	count  int
+}
+
+func (h *storeEventHistory) add(event StoreEvent) {
+	h.Lock()
+	defer h.Unlock()
+	h.events[h.count%storeEventHistorySize] = event
+	h.count++
+}
+
+// recent returns the events from the oldest to the newest.
+func (h *storeEventHistory) recent() []StoreEvent {
+	h.Lock()
+	defer h.Unlock()
+	n, start := h.count, 0
+	if h.count > storeEventHistorySize {
+		n, start = storeEventHistorySize, h.count%storeEventHistorySize
+	}
+	events := make([]StoreEvent, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, h.events[(start+i)%storeEventHistorySize])
+	}
+	return events
 }
 
 // NewStoreInfo creates StoreInfo with meta data.
 func NewStoreInfo(store *metapb.Store, opts ...StoreCreateOption) *StoreInfo {
 	storeInfo := &StoreInfo{
-		meta:              store,
-		stats:             &pdpb.StoreStats{},
-		leaderWeight:      1.0,
-		regionWeight:      1.0,
-		rollingStoreStats: newRollingStoreStats(),
+		meta:               store,
+		labelsFingerprint:  labelsFingerprint(store.GetLabels()),
+		stats:              &pdpb.StoreStats{},
+		leaderWeight:       1.0,
+		regionWeight:       1.0,
+		rollingStoreStats:  newRollingStoreStats(),
+		reservedRegionSize: new(int64),
+		scoreCache:         &regionScoreCache{},
+		events:             &storeEventHistory{},
 	}
 	for _, opt := range opts {
 		opt(storeInfo)
@@ -58,21 +164,40 @@ func NewStoreInfo(store *metapb.Store, opts ...StoreCreateOption) *StoreInfo {
 	return storeInfo
 }
 
-// Clone creates a copy of current StoreInfo.
+// Clone creates a copy of current StoreInfo. The copy shares the rolling
+// statistics with the origin, use DeepCloneStats to get independent ones.
 func (s *StoreInfo) Clone(opts ...StoreCreateOption) *StoreInfo {
 	store := &StoreInfo{
-		meta:              s.meta,
-		stats:             s.stats,
-		blocked:           s.blocked,
-		leaderCount:       s.leaderCount,
-		regionCount:       s.regionCount,
-		leaderSize:        s.leaderSize,
-		regionSize:        s.regionSize,
-		pendingPeerCount:  s.pendingPeerCount,
-		lastHeartbeatTS:   s.lastHeartbeatTS,
-		leaderWeight:      s.leaderWeight,
-		regionWeight:      s.regionWeight,
-		rollingStoreStats: s.rollingStoreStats,
+		meta:               s.meta,
+		labelsFingerprint:  s.labelsFingerprint,
+		stats:              s.stats,
+		blocked:            s.blocked,
+		leaderCount:        s.leaderCount,
+		regionCount:        s.regionCount,
+		leaderSize:         s.leaderSize,
+		regionSize:         s.regionSize,
+		pendingPeerCount:   s.pendingPeerCount,
+		lastHeartbeatTS:    s.lastHeartbeatTS,
+		leaderWeight:       s.leaderWeight,
+		regionWeight:       s.regionWeight,
+		rollingStoreStats:  s.rollingStoreStats,
+		applyLatency:       s.applyLatency,
+		commitLatency:      s.commitLatency,
+		addPeerLimit:       s.addPeerLimit,
+		removePeerLimit:    s.removePeerLimit,
+		prevCapacity:       s.prevCapacity,
+		frozen:             s.frozen,
+		readOnly:           s.readOnly,
+		draining:           s.draining,
+		heartbeatIntervals: s.heartbeatIntervals,
+		blockCount:         s.blockCount,
+		lastBlockTime:      s.lastBlockTime,
+		reservedRegionSize: s.reservedRegionSize,
+		scoreCache:         &regionScoreCache{},
+		pauseUntil:         s.pauseUntil,
+		upTransitionTime:   s.upTransitionTime,
+		tombstoneTime:      s.tombstoneTime,
+		events:             s.events,
 	}
 
 	for _, opt := range opts {
@@ -81,11 +206,143 @@ func (s *StoreInfo) Clone(opts ...StoreCreateOption) *StoreInfo {
 	return store
 }
 
+// Equal checks if the store has the same meta, counts, sizes, weights and
+// statistics with other. The statistics and rolling statistics are compared
+// by pointer rather than by content.
+func (s *StoreInfo) Equal(other *StoreInfo) bool {
+	if s == other {
+		return true
+	}
+	if s == nil || other == nil {
+		return false
+	}
+	return s.GetID() == other.GetID() &&
+		s.GetState() == other.GetState() &&
+		s.GetAddress() == other.GetAddress() &&
+		s.GetVersion() == other.GetVersion() &&
+		labelsEqual(s.GetLabels(), other.GetLabels()) &&
+		s.blocked == other.blocked &&
+		s.frozen == other.frozen &&
+		s.readOnly == other.readOnly &&
+		s.draining == other.draining &&
+		s.leaderCount == other.leaderCount &&
+		s.regionCount == other.regionCount &&
+		s.pendingPeerCount == other.pendingPeerCount &&
+		s.leaderSize == other.leaderSize &&
+		s.regionSize == other.regionSize &&
+		s.leaderWeight == other.leaderWeight &&
+		s.regionWeight == other.regionWeight &&
+		s.stats == other.stats &&
+		s.rollingStoreStats == other.rollingStoreStats
+}
+
+func labelsEqual(a, b []*metapb.StoreLabel) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].GetKey() != b[i].GetKey() || a[i].GetValue() != b[i].GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
 // IsBlocked returns if the store is blocked.
 func (s *StoreInfo) IsBlocked() bool {
 	return s.blocked
 }
 
+const (
+	// blockCountDecayInterval is the interval for the block count to decrease
+	// by one.
+	blockCountDecayInterval = 10 * time.Minute
+	maxBlockBackoff         = 30 * time.Minute
+)
+
+// GetBlockCount returns the number of times the store is blocked, decayed by
+// the time since it is last blocked.
+func (s *StoreInfo) GetBlockCount() int {
+	if s.blockCount == 0 {
+		return 0
+	}
+	decayed := int(since(s.lastBlockTime) / blockCountDecayInterval)
+	if decayed >= s.blockCount {
+		return 0
+	}
+	return s.blockCount - decayed
+}
+
+// GetLastBlockTime returns the last time the store is blocked.
+func (s *StoreInfo) GetLastBlockTime() time.Time {
+	return s.lastBlockTime
+}
+
+// SuggestedBackoff returns the suggested time to back off before scheduling
+// on the store again, which is base * 2^blockCount and capped at
+// maxBlockBackoff.
+func (s *StoreInfo) SuggestedBackoff(base time.Duration) time.Duration {
+	backoff := base
+	for i := 0; i < s.GetBlockCount() && backoff < maxBlockBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBlockBackoff {
+		return maxBlockBackoff
+	}
+	return backoff
+}
+
+// IsReadOnly returns if the store is read-only.
+func (s *StoreInfo) IsReadOnly() bool {
+	return s.readOnly
+}
+
+// AllowAddPeer returns if new peers can be added to the store. Read-only
+// stores are still available for reads but are not eligible for add-peer.
+func (s *StoreInfo) AllowAddPeer() bool {
+	return s.IsUp() && !s.readOnly && !s.SchedulingPaused()
+}
+
+// IsDraining returns if the store is draining leaders.
+func (s *StoreInfo) IsDraining() bool {
+	return s.draining
+}
+
+// AllowLeaderTransferIn returns if leaders can be transferred to the store.
+// Draining stores are not eligible, while they are still eligible for
+// add-peer. Neither is allowed while scheduling to the store is paused.
+func (s *StoreInfo) AllowLeaderTransferIn() bool {
+	return s.IsUp() && !s.draining && !s.SchedulingPaused()
+}
+
+// RecordEvent records an event of the store with the current time. Only the
+// last storeEventHistorySize events are kept.
+func (s *StoreInfo) RecordEvent(kind, detail string) {
+	s.events.add(StoreEvent{Time: now(), Kind: kind, Detail: detail})
+}
+
+// GetRecentEvents returns the recent events of the store from the oldest to
+// the newest.
+func (s *StoreInfo) GetRecentEvents() []StoreEvent {
+	return s.events.recent()
+}
+
+// SchedulingPaused returns true if scheduling to the store is paused and the
+// deadline has not passed.
+func (s *StoreInfo) SchedulingPaused() bool {
+	return now().Before(s.pauseUntil)
+}
+
+// GetPauseUntil returns the deadline of the scheduling pause.
+func (s *StoreInfo) GetPauseUntil() time.Time {
+	return s.pauseUntil
+}
+
+// IsFrozen returns if the statistics of the store are frozen.
+func (s *StoreInfo) IsFrozen() bool {
+	return s.frozen
+}
+
 // IsUp checks if the store's state is Up.
 func (s *StoreInfo) IsUp() bool {
 	return s.GetState() == metapb.StoreState_Up
@@ -101,9 +358,25 @@ func (s *StoreInfo) IsTombstone() bool {
 	return s.GetState() == metapb.StoreState_Tombstone
 }
 
+// StatsAreStale returns true if the store has not reported valid statistics
+// for longer than maxAge, even if it keeps sending heartbeats.
+func (s *StoreInfo) StatsAreStale(maxAge time.Duration) bool {
+	return since(s.GetRollingStoreStats().GetLastUpdateTime()) > maxAge
+}
+
+// TombstonedFor returns the time elapsed since the store becomes Tombstone.
+// It is zero if the store is not Tombstone or the time is unknown, e.g. the
+// store was tombstoned before PD starts.
+func (s *StoreInfo) TombstonedFor() time.Duration {
+	if !s.IsTombstone() || s.tombstoneTime.IsZero() {
+		return 0
+	}
+	return since(s.tombstoneTime)
+}
+
 // DownTime returns the time elapsed since last heartbeat.
 func (s *StoreInfo) DownTime() time.Duration {
-	return time.Since(s.GetLastHeartbeatTS())
+	return since(s.GetLastHeartbeatTS())
 }
 
 // GetMeta returns the meta information of the store.
@@ -146,6 +419,17 @@ func (s *StoreInfo) GetCapacity() uint64 {
 	return s.stats.GetCapacity()
 }
 
+// CapacityChangedSinceLastHeartbeat returns the signed change of capacity
+// between the last 2 reported statistics, and whether it is changed. It is
+// considered unchanged if there is no previous capacity reported.
+func (s *StoreInfo) CapacityChangedSinceLastHeartbeat() (int64, bool) {
+	if s.prevCapacity == 0 {
+		return 0, false
+	}
+	delta := int64(s.GetCapacity()) - int64(s.prevCapacity)
+	return delta, delta != 0
+}
+
 // GetAvailable returns the available size of the store.
 func (s *StoreInfo) GetAvailable() uint64 {
 	return s.stats.GetAvailable()
@@ -241,13 +525,134 @@ func (s *StoreInfo) GetLastHeartbeatTS() time.Time {
 	return s.lastHeartbeatTS
 }
 
+// heartbeatIntervalWindow is the number of recent heartbeat intervals kept.
+const heartbeatIntervalWindow = 5
+
+// AverageHeartbeatInterval returns the average interval between the last few
+// heartbeats of the store.
+func (s *StoreInfo) AverageHeartbeatInterval() time.Duration {
+	if len(s.heartbeatIntervals) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, interval := range s.heartbeatIntervals {
+		total += interval
+	}
+	return total / time.Duration(len(s.heartbeatIntervals))
+}
+
+// MaxHeartbeatInterval returns the maximum interval between the last few
+// heartbeats of the store.
+func (s *StoreInfo) MaxHeartbeatInterval() time.Duration {
+	var max time.Duration
+	for _, interval := range s.heartbeatIntervals {
+		if interval > max {
+			max = interval
+		}
+	}
+	return max
+}
+
 // GetRollingStoreStats returns the rolling statistics of the store.
 func (s *StoreInfo) GetRollingStoreStats() *RollingStoreStats {
 	return s.rollingStoreStats
 }
 
+// GetApplyLatency returns the apply latency reported by the store. It is zero
+// if the store does not report it.
+func (s *StoreInfo) GetApplyLatency() time.Duration {
+	return s.applyLatency
+}
+
+// GetCommitLatency returns the commit latency reported by the store. It is
+// zero if the store does not report it.
+func (s *StoreInfo) GetCommitLatency() time.Duration {
+	return s.commitLatency
+}
+
+// GetAddPeerLimit returns the limit of adding peer operations of the store.
+// Zero means unlimited.
+func (s *StoreInfo) GetAddPeerLimit() int {
+	return s.addPeerLimit
+}
+
+// GetRemovePeerLimit returns the limit of removing peer operations of the
+// store. Zero means unlimited.
+func (s *StoreInfo) GetRemovePeerLimit() int {
+	return s.removePeerLimit
+}
+
+// IsLimitExceeded checks if current operations of the kind reach the limit
+// of the store, so no more operations of the kind should be scheduled.
+func (s *StoreInfo) IsLimitExceeded(kind PeerOperationKind, current int) bool {
+	var limit int
+	switch kind {
+	case AddPeerKind:
+		limit = s.GetAddPeerLimit()
+	case RemovePeerKind:
+		limit = s.GetRemovePeerLimit()
+	}
+	return limit > 0 && current >= limit
+}
+
 const minWeight = 1e-6
-const maxScore = 1024 * 1024 * 1024
+
+const defaultMaxScore = 1024 * 1024 * 1024
+
+// scoreVersion is bumped when the package level settings used by RegionScore
+// change, so that the cached scores computed before are not used.
+var scoreVersion uint64
+
+// maxScoreBits holds the bits of the ceiling of the region score in the low
+// space stage, it should be greater than the region size of any store. It is
+// accessed atomically so that it can be changed while scheduling.
+var maxScoreBits = math.Float64bits(defaultMaxScore)
+
+func getMaxScore() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&maxScoreBits))
+}
+
+// SetMaxScore sets the ceiling of the region score in the low space stage.
+func SetMaxScore(v float64) errcode.ErrorCode {
+	if v <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return errcode.NewInvalidInputErr(errors.Errorf("invalid max score: %v", v))
+	}
+	atomic.StoreUint64(&maxScoreBits, math.Float64bits(v))
+	atomic.AddUint64(&scoreVersion, 1)
+	return nil
+}
+
+// GetReservedRegionSize returns the region size reserved by in-flight
+// operations.
+func (s *StoreInfo) GetReservedRegionSize() int64 {
+	return atomic.LoadInt64(s.reservedRegionSize)
+}
+
+// ReserveRegionSize reserves delta region size for an in-flight operation.
+func (s *StoreInfo) ReserveRegionSize(delta int64) {
+	if delta <= 0 {
+		return
+	}
+	atomic.AddInt64(s.reservedRegionSize, delta)
+}
+
+// ReleaseRegionSize releases delta reserved region size, the reservation
+// never goes negative.
+func (s *StoreInfo) ReleaseRegionSize(delta int64) {
+	if delta <= 0 {
+		return
+	}
+	for {
+		old := atomic.LoadInt64(s.reservedRegionSize)
+		reserved := old - delta
+		if reserved < 0 {
+			reserved = 0
+		}
+		if atomic.CompareAndSwapInt64(s.reservedRegionSize, old, reserved) {
+			return
+		}
+	}
+}
 
 // LeaderScore returns the store's leader score: leaderSize / leaderWeight.
 func (s *StoreInfo) LeaderScore(delta int64) float64 {
@@ -256,14 +661,266 @@ func (s *StoreInfo) LeaderScore(delta int64) float64 {
 
 // RegionScore returns the store's region score.
 func (s *StoreInfo) RegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64) float64 {
+	return s.rawRegionScore(highSpaceRatio, lowSpaceRatio, delta) / math.Max(s.GetRegionWeight(), minWeight)
+}
+
+// SimpleRegionScore returns the region size divided by the available space
+// in MB and the region weight. Unlike RegionScore, it has no space stages and
+// ignores highSpaceRatio and lowSpaceRatio entirely.
+func (s *StoreInfo) SimpleRegionScore() float64 {
+	available := math.Max(float64(s.GetAvailable())/(1<<20), 1)
+	return float64(s.GetRegionSize()) / available / math.Max(s.GetRegionWeight(), minWeight)
+}
+
+// regionScoreComputeHook is called when RegionScoreCached computes the score,
+// it is used for testing.
+var regionScoreComputeHook func()
+
+// RegionScoreCached works like RegionScore with zero delta, but caches the
+// score for the same space ratios until SetMaxScore or SetSmoothAmplification
+// is called.
+func (s *StoreInfo) RegionScoreCached(highSpaceRatio, lowSpaceRatio float64) float64 {
+	cache := s.scoreCache
+	cache.Lock()
+	defer cache.Unlock()
+	version := atomic.LoadUint64(&scoreVersion)
+	if cache.valid && cache.version == version && cache.highSpaceRatio == highSpaceRatio && cache.lowSpaceRatio == lowSpaceRatio {
+		return cache.score
+	}
+	if regionScoreComputeHook != nil {
+		regionScoreComputeHook()
+	}
+	cache.valid, cache.version = true, version
+	cache.highSpaceRatio, cache.lowSpaceRatio = highSpaceRatio, lowSpaceRatio
+	cache.score = s.RegionScore(highSpaceRatio, lowSpaceRatio, 0)
+	return cache.score
+}
+
+const (
+	// regionWeightWarmup is the duration for the region weight of a store to
+	// ramp up after it becomes Up again.
+	regionWeightWarmup = 10 * time.Minute
+	// warmupStartWeightRatio is the ratio of the region weight when the
+	// warmup starts.
+	warmupStartWeightRatio = 0.1
+)
+
+// EffectiveRegionWeight returns the region weight of the store at now. It
+// interpolates linearly from a small value up to the region weight over
+// regionWeightWarmup since the store becomes Up again.
+func (s *StoreInfo) EffectiveRegionWeight(now time.Time) float64 {
+	weight := s.GetRegionWeight()
+	if s.upTransitionTime.IsZero() {
+		return weight
+	}
+	elapsed := now.Sub(s.upTransitionTime)
+	if elapsed >= regionWeightWarmup {
+		return weight
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	start := weight * warmupStartWeightRatio
+	return start + (weight-start)*float64(elapsed)/float64(regionWeightWarmup)
+}
+
+// WarmRegionScore works like RegionScore, but uses the effective region
+// weight at now, so a store which becomes Up again gets load gradually.
+func (s *StoreInfo) WarmRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64, now time.Time) float64 {
+	return s.rawRegionScore(highSpaceRatio, lowSpaceRatio, delta) / math.Max(s.EffectiveRegionWeight(now), minWeight)
+}
+
+// ReservedRegionScore works like RegionScore, but also includes the region
+// size reserved by in-flight operations.
+func (s *StoreInfo) ReservedRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64) float64 {
+	return s.RegionScore(highSpaceRatio, lowSpaceRatio, delta+s.GetReservedRegionSize())
+}
+
+// PenalizedRegionScore works like RegionScore, but adds pendingPenalty for
+// each pending peer to the score, so stores with many pending peers look more
+// loaded.
+func (s *StoreInfo) PenalizedRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64, pendingPenalty float64) float64 {
+	score := s.rawRegionScore(highSpaceRatio, lowSpaceRatio, delta) + pendingPenalty*float64(s.GetPendingPeerCount())
+	return score / math.Max(s.GetRegionWeight(), minWeight)
+}
+
+// Headroom returns the available bytes of the store.
+func (s *StoreInfo) Headroom() uint64 {
+	return s.GetAvailable()
+}
+
+// HeadroomAfter returns the available bytes of the store after placing delta
+// bytes on it, which is negative if the store is over-committed.
+func (s *StoreInfo) HeadroomAfter(delta int64) int64 {
+	return int64(s.GetAvailable()) - delta
+}
+
+// AverageRegionSize returns the average size of the regions in the store, it
+// is 0 if the store has no region.
+func (s *StoreInfo) AverageRegionSize() int64 {
+	if s.GetRegionCount() <= 0 {
+		return 0
+	}
+	return s.GetRegionSize() / int64(s.GetRegionCount())
+}
+
+// CompressionAmplification returns the ratio of the region size to the size
+// actually used by the store. It is 1 if the used size is unknown.
+func (s *StoreInfo) CompressionAmplification() float64 {
+	used := float64(s.GetUsedSize()) / (1 << 20)
+	if used == 0 {
+		return 1
+	}
+	return float64(s.GetRegionSize()) / used
+}
+
+// BetterLeaderTargetThan returns true if the store is a better target to
+// transfer leaders to than other. A store can be a target only if it is Up,
+// not draining, not busy and not disconnected. Among the possible targets,
+// the one with the lower leader score is better, and ties fall back to the
+// lower store ID.
+func (s *StoreInfo) BetterLeaderTargetThan(other *StoreInfo) bool {
+	if !s.canBeLeaderTarget() {
+		return false
+	}
+	if !other.canBeLeaderTarget() {
+		return true
+	}
+	if score, otherScore := s.LeaderScore(0), other.LeaderScore(0); score != otherScore {
+		return score < otherScore
+	}
+	return s.GetID() < other.GetID()
+}
+
+func (s *StoreInfo) canBeLeaderTarget() bool {
+	return s.AllowLeaderTransferIn() && !s.GetIsBusy() && !s.IsDisconnected()
+}
+
+// CanHostRegion returns false if placing a region of regionSize on the store
+// would push its available space below the low space bound. The growth of the
+// used size is projected with the compression amplification of the store.
+func (s *StoreInfo) CanHostRegion(regionSize int64, lowSpaceRatio float64) bool {
+	available := float64(s.GetAvailable()) / (1 << 20)
+	capacity := float64(s.GetCapacity()) / (1 << 20)
+	growth := float64(regionSize) / s.CompressionAmplification()
+	return available-growth >= (1-lowSpaceRatio)*capacity
+}
+
+// MoveCost returns the relative cost of moving a region of regionSize onto the
+// store, which is regionSize * (1 + snapCount) / max(availableMB, 1), and
+// doubled if the store is busy. snapCount is the sum of the sending, receiving
+// and applying snapshot counts. Larger, busier and fuller stores cost more.
+func (s *StoreInfo) MoveCost(regionSize int64) float64 {
+	available := math.Max(float64(s.GetAvailable())/(1<<20), 1)
+	cost := float64(regionSize) * float64(1+s.snapCount()) / available
+	if s.GetIsBusy() {
+		cost *= 2
+	}
+	return cost
+}
+
+func (s *StoreInfo) snapCount() uint32 {
+	return s.GetSendingSnapCount() + s.GetReceivingSnapCount() + s.GetApplyingSnapCount()
+}
+
+const defaultSnapshotCapacity = 16
+
+// snapshotCapacityBits holds the bits of the number of concurrent snapshots
+// that a store is regarded as fully loaded with. It is accessed atomically so
+// that it can be changed while scheduling.
+var snapshotCapacityBits = math.Float64bits(defaultSnapshotCapacity)
+
+func getSnapshotCapacity() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&snapshotCapacityBits))
+}
+
+// smoothAmplification is 1 if RegionScore uses the median of the recently
+// observed amplifications, which is stable right after compactions. It is
+// accessed atomically so that it can be changed while scheduling.
+var smoothAmplification int32
+
+func smoothAmplificationEnabled() bool {
+	return atomic.LoadInt32(&smoothAmplification) == 1
+}
+
+// SetSmoothAmplification sets whether RegionScore uses the smoothed
+// amplification instead of the instantaneous one. It applies to all the
+// stores in the process.
+func SetSmoothAmplification(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&smoothAmplification, v)
+	atomic.AddUint64(&scoreVersion, 1)
+}
+
+// SetSnapshotCapacity sets the number of concurrent snapshots that
+// SnapshotPressure normalizes by.
+func SetSnapshotCapacity(v float64) errcode.ErrorCode {
+	if v <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return errcode.NewInvalidInputErr(errors.Errorf("invalid snapshot capacity: %v", v))
+	}
+	atomic.StoreUint64(&snapshotCapacityBits, math.Float64bits(v))
+	return nil
+}
+
+// SnapshotPressure returns the sum of the sending, receiving and applying
+// snapshot counts normalized by the snapshot capacity. Unlike the busy flag,
+// it grows continuously with the snapshot load, and may exceed 1.
+func (s *StoreInfo) SnapshotPressure() float64 {
+	return float64(s.snapCount()) / getSnapshotCapacity()
+}
+
+// rawRegionScore returns the store's region score before dividing by weight.
+func (s *StoreInfo) rawRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64) float64 {
+	return s.explainRawRegionScore(highSpaceRatio, lowSpaceRatio, delta).RawScore
+}
+
+// RegionScoreExplanation contains the intermediate values of RegionScore.
+// K and B are only set in the transition stage.
+type RegionScoreExplanation struct {
+	Stage          SpaceStage `json:"stage"`
+	Amplification  float64    `json:"amplification"`
+	HighSpaceBound float64    `json:"high_space_bound"`
+	LowSpaceBound  float64    `json:"low_space_bound"`
+	K              float64    `json:"k"`
+	B              float64    `json:"b"`
+	RawScore       float64    `json:"raw_score"`
+	Weight         float64    `json:"weight"`
+	Score          float64    `json:"score"`
+}
+
+// ExplainRegionScore returns how RegionScore is computed, which helps to
+// debug the score of a store.
+func (s *StoreInfo) ExplainRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64) RegionScoreExplanation {
+	e := s.explainRawRegionScore(highSpaceRatio, lowSpaceRatio, delta)
+	e.Weight = math.Max(s.GetRegionWeight(), minWeight)
+	e.Score = e.RawScore / e.Weight
+	return e
+}
+
+// smoothedAmplification returns the median of the observed amplifications,
+// or 0 if smoothAmplification is disabled or nothing is observed.
+func (s *StoreInfo) smoothedAmplification() float64 {
+	if !smoothAmplificationEnabled() {
+		return 0
+	}
+	return s.GetRollingStoreStats().GetAmplification()
+}
+
+func (s *StoreInfo) explainRawRegionScore(highSpaceRatio, lowSpaceRatio float64, delta int64) RegionScoreExplanation {
 	var score float64
 	var amplification float64
+	var e RegionScoreExplanation
 	available := float64(s.GetAvailable()) / (1 << 20)
 	used := float64(s.GetUsedSize()) / (1 << 20)
 	capacity := float64(s.GetCapacity()) / (1 << 20)
 
 	if s.GetRegionSize() == 0 {
 		amplification = 1
+	} else if smoothed := s.smoothedAmplification(); smoothed > 0 {
+		amplification = smoothed
 	} else {
 		// because of rocksdb compression, region size is larger than actual used size
 		amplification = float64(s.GetRegionSize()) / used
@@ -274,10 +931,13 @@ func (s *StoreInfo) RegionScore(highSpaceRatio, lowSpaceRatio float64, delta int
 	// lowSpaceBound is the upper bound of the low space stage.
 	lowSpaceBound := (1 - lowSpaceRatio) * capacity
 	if available-float64(delta)/amplification >= highSpaceBound {
+		e.Stage = HighSpace
 		score = float64(s.GetRegionSize() + delta)
 	} else if available-float64(delta)/amplification <= lowSpaceBound {
-		score = maxScore - (available - float64(delta)/amplification)
+		e.Stage = LowSpace
+		score = getMaxScore() - (available - float64(delta)/amplification)
 	} else {
+		e.Stage = TransitionSpace
 		// to make the score function continuous, we use linear function y = k * x + b as transition period
 		// from above we know that there are two points must on the function image
 		// note that it is possible that other irrelative files occupy a lot of storage, so capacity == available + used + irrelative
@@ -290,14 +950,202 @@ func (s *StoreInfo) RegionScore(highSpaceRatio, lowSpaceRatio float64, delta int
 		// we can conclude that size = (capacity - irrelative - lowSpaceBound) * amp = (used + available - lowSpaceBound) * amp
 		// These are the two fixed points' x-coordinates, and y-coordinates which can be easily obtained from the above two functions.
 		x1, y1 := (used+available-highSpaceBound)*amplification, (used+available-highSpaceBound)*amplification
-		x2, y2 := (used+available-lowSpaceBound)*amplification, maxScore-lowSpaceBound
+		x2, y2 := (used+available-lowSpaceBound)*amplification, getMaxScore()-lowSpaceBound
 
 		k := (y2 - y1) / (x2 - x1)
 		b := y1 - k*x1
 		score = k*float64(s.GetRegionSize()+delta) + b
+		e.K, e.B = k, b
 	}
 
-	return score / math.Max(s.GetRegionWeight(), minWeight)
+	// The score is not finite when the store reports region size but no used
+	// size, since the amplification is infinite then, or when the transition
+	// stage has no width because highSpaceRatio == lowSpaceRatio, since the
+	// linear solve divides by x2 - x1. It should not corrupt the order.
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		invalidScoreWarning.Do(func() {
+			log.Warnf("[store %d] invalid region score with region size %d and used size %d", s.GetID(), s.GetRegionSize(), s.GetUsedSize())
+		})
+		score = float64(s.GetRegionSize() + delta)
+	}
+	e.Amplification = amplification
+	e.HighSpaceBound, e.LowSpaceBound = highSpaceBound, lowSpaceBound
+	e.RawScore = score
+	return e
+}
+
+var invalidScoreWarning sync.Once
+
+// SpaceStage indicates which stage of RegionScore a store's space is in.
+type SpaceStage int
+
+// Space stages of a store.
+const (
+	HighSpace SpaceStage = iota
+	TransitionSpace
+	LowSpace
+)
+
+func (s SpaceStage) String() string {
+	switch s {
+	case HighSpace:
+		return "high"
+	case TransitionSpace:
+		return "transition"
+	case LowSpace:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+// SpaceStage returns the stage of the store's available space, using the same
+// bounds as RegionScore.
+func (s *StoreInfo) SpaceStage(highSpaceRatio, lowSpaceRatio float64) SpaceStage {
+	available := float64(s.GetAvailable()) / (1 << 20)
+	capacity := float64(s.GetCapacity()) / (1 << 20)
+	highSpaceBound := (1 - highSpaceRatio) * capacity
+	lowSpaceBound := (1 - lowSpaceRatio) * capacity
+	if available >= highSpaceBound {
+		return HighSpace
+	} else if available <= lowSpaceBound {
+		return LowSpace
+	}
+	return TransitionSpace
+}
+
+// IneligibleReasons returns the reasons why the store cannot be scheduled
+// currently, or an empty slice if it is fully eligible. The reason strings are
+// stable for diagnostics.
+func (s *StoreInfo) IneligibleReasons(highSpaceRatio, lowSpaceRatio float64) []string {
+	reasons := []string{}
+	switch {
+	case s.IsTombstone():
+		reasons = append(reasons, "tombstone")
+	case s.IsOffline():
+		reasons = append(reasons, "offline")
+	}
+	if s.IsDisconnected() {
+		reasons = append(reasons, "disconnected")
+	}
+	if s.IsBlocked() {
+		reasons = append(reasons, "blocked")
+	}
+	if s.IsReadOnly() {
+		reasons = append(reasons, "read-only")
+	}
+	if s.SchedulingPaused() {
+		reasons = append(reasons, "paused")
+	}
+	if s.GetIsBusy() {
+		reasons = append(reasons, "busy")
+	}
+	if s.SpaceStage(highSpaceRatio, lowSpaceRatio) == LowSpace {
+		reasons = append(reasons, "low space")
+	}
+	return reasons
+}
+
+// maxReadWriteRatio is the read/write ratio of stores without write flow.
+const maxReadWriteRatio = 1 << 20
+
+// ReadWriteRatio returns the ratio of the bytes read rate to the bytes write
+// rate. It is capped at maxReadWriteRatio when the write rate is zero, and is
+// zero if the store has no flow.
+func (s *StoreInfo) ReadWriteRatio() float64 {
+	readRate := s.GetRollingStoreStats().GetBytesReadRate()
+	writeRate := s.GetRollingStoreStats().GetBytesWriteRate()
+	if writeRate == 0 {
+		if readRate == 0 {
+			return 0
+		}
+		return maxReadWriteRatio
+	}
+	return math.Min(readRate/writeRate, maxReadWriteRatio)
+}
+
+// FlowStat contains the bytes rate and keys rate of a flow.
+type FlowStat struct {
+	Bytes float64 `json:"bytes"`
+	Keys  float64 `json:"keys"`
+}
+
+// ReadFlow returns the read flow of the store.
+func (s *StoreInfo) ReadFlow() FlowStat {
+	stats := s.GetRollingStoreStats()
+	return FlowStat{Bytes: stats.GetBytesReadRate(), Keys: stats.GetKeysReadRate()}
+}
+
+// WriteFlow returns the write flow of the store.
+func (s *StoreInfo) WriteFlow() FlowStat {
+	stats := s.GetRollingStoreStats()
+	return FlowStat{Bytes: stats.GetBytesWriteRate(), Keys: stats.GetKeysWriteRate()}
+}
+
+// HotnessClass classifies a store by its read and write flow.
+type HotnessClass int
+
+// Hotness classes of a store.
+const (
+	ColdStore HotnessClass = iota
+	WriteHotStore
+	ReadHotStore
+	MixedHotStore
+)
+
+func (h HotnessClass) String() string {
+	switch h {
+	case ColdStore:
+		return "cold"
+	case WriteHotStore:
+		return "write-hot"
+	case ReadHotStore:
+		return "read-hot"
+	case MixedHotStore:
+		return "mixed-hot"
+	default:
+		return "unknown"
+	}
+}
+
+// HotnessClass classifies the store by whether its bytes write rate and bytes
+// read rate exceed the thresholds.
+func (s *StoreInfo) HotnessClass(writeThreshold, readThreshold float64) HotnessClass {
+	writeHot := s.GetRollingStoreStats().GetBytesWriteRate() > writeThreshold
+	readHot := s.GetRollingStoreStats().GetBytesReadRate() > readThreshold
+	switch {
+	case writeHot && readHot:
+		return MixedHotStore
+	case writeHot:
+		return WriteHotStore
+	case readHot:
+		return ReadHotStore
+	default:
+		return ColdStore
+	}
+}
+
+// NeverLowSpace is returned by TimeToLowSpace if the store is not written.
+const NeverLowSpace = time.Duration(math.MaxInt64)
+
+// TimeToLowSpace estimates the time until the store enters the low space
+// stage, by its current bytes write rate. It returns 0 if the store is
+// already in low space, and NeverLowSpace if the write rate is zero.
+func (s *StoreInfo) TimeToLowSpace(lowSpaceRatio float64) time.Duration {
+	lowSpaceBound := (1 - lowSpaceRatio) * float64(s.GetCapacity())
+	remaining := float64(s.GetAvailable()) - lowSpaceBound
+	if remaining <= 0 {
+		return 0
+	}
+	rate := s.GetRollingStoreStats().GetBytesWriteRate()
+	if rate <= 0 {
+		return NeverLowSpace
+	}
+	seconds := remaining / rate
+	if seconds >= float64(NeverLowSpace/time.Second) {
+		return NeverLowSpace
+	}
+	return time.Duration(seconds * float64(time.Second))
 }
 
 // StorageSize returns store's used storage size reported from tikv.
@@ -310,7 +1158,29 @@ func (s *StoreInfo) AvailableRatio() float64 {
 	if s.GetCapacity() == 0 {
 		return 0
 	}
-	return float64(s.GetAvailable()) / float64(s.GetCapacity())
+	return float64(s.SanitizedAvailable()) / float64(s.GetCapacity())
+}
+
+// SanitizedAvailable returns the available size of the store, capped by the
+// capacity. The statistics may report available greater than capacity, e.g.
+// when the disk is being expanded.
+func (s *StoreInfo) SanitizedAvailable() uint64 {
+	if available := s.GetAvailable(); available < s.GetCapacity() {
+		return available
+	}
+	return s.GetCapacity()
+}
+
+// spaceAccountingTolerance is the ratio of the capacity that used plus
+// available may exceed it by.
+const spaceAccountingTolerance = 0.01
+
+// SpaceAccountingValid returns false if the used size plus the available size
+// exceeds the capacity beyond spaceAccountingTolerance, which breaks the
+// assumption of RegionScore that the irrelative size is fixed.
+func (s *StoreInfo) SpaceAccountingValid() bool {
+	total := float64(s.GetUsedSize()) + float64(s.GetAvailable())
+	return total <= float64(s.GetCapacity())*(1+spaceAccountingTolerance)
 }
 
 // IsLowSpace checks if the store is lack of space.
@@ -318,6 +1188,42 @@ func (s *StoreInfo) IsLowSpace(lowSpaceRatio float64) bool {
 	return s.GetStoreStats() != nil && s.AvailableRatio() < 1-lowSpaceRatio
 }
 
+// StatsConsistent checks if the counts and sizes of the store agree with each
+// other, and returns the reason if not. An empty store is consistent.
+func (s *StoreInfo) StatsConsistent() (bool, string) {
+	if (s.GetRegionCount() > 0) != (s.GetRegionSize() > 0) {
+		return false, fmt.Sprintf("region count %d disagrees with region size %d", s.GetRegionCount(), s.GetRegionSize())
+	}
+	if (s.GetLeaderCount() > 0) != (s.GetLeaderSize() > 0) {
+		return false, fmt.Sprintf("leader count %d disagrees with leader size %d", s.GetLeaderCount(), s.GetLeaderSize())
+	}
+	return true, ""
+}
+
+// DecommissionProgress returns the ratio of the regions moved out of the store
+// since it held initialRegionCount regions, which is in [0, 1]. It returns 1.0
+// when the store holds no regions.
+func (s *StoreInfo) DecommissionProgress(initialRegionCount int) float64 {
+	regionCount := s.GetRegionCount()
+	if regionCount <= 0 {
+		return 1.0
+	}
+	if initialRegionCount <= 0 {
+		return 0
+	}
+	progress := 1 - float64(regionCount)/float64(initialRegionCount)
+	return math.Min(math.Max(progress, 0), 1)
+}
+
+// HasInvalidCounts returns true if any count of the store is negative or the
+// leader count exceeds the region count, since every leader is also a region.
+func (s *StoreInfo) HasInvalidCounts() bool {
+	if s.GetLeaderCount() < 0 || s.GetRegionCount() < 0 || s.GetPendingPeerCount() < 0 {
+		return true
+	}
+	return s.GetLeaderCount() > s.GetRegionCount()
+}
+
 // ResourceCount reutrns count of leader/region in the store.
 func (s *StoreInfo) ResourceCount(kind ResourceKind) uint64 {
 	switch kind {
@@ -388,6 +1294,13 @@ func (s *StoreInfo) GetUptime() time.Duration {
 	return 0
 }
 
+// HasClockSkew checks if the start time of the store is more than tolerance
+// later than its last heartbeat, which is caused by clock skew between PD and
+// the store.
+func (s *StoreInfo) HasClockSkew(tolerance time.Duration) bool {
+	return s.GetStartTS().Sub(s.GetLastHeartbeatTS()) > tolerance
+}
+
 var (
 	// If a store's last heartbeat is storeDisconnectDuration ago, the store will
 	// be marked as disconnected state. The value should be greater than tikv's
@@ -418,21 +1331,173 @@ func (s *StoreInfo) GetLabelValue(key string) string {
 	return ""
 }
 
-// CompareLocation compares 2 stores' labels and returns at which level their
-// locations are different. It returns -1 if they are at the same location.
-func (s *StoreInfo) CompareLocation(other *StoreInfo, labels []string) int {
-	for i, key := range labels {
-		v1, v2 := s.GetLabelValue(key), other.GetLabelValue(key)
-		// If label is not set, the store is considered at the same location
-		// with any other store.
-		if v1 != "" && v2 != "" && !strings.EqualFold(v1, v2) {
-			return i
+// MatchesLabels returns true if the store has all the required labels. Both
+// keys and values are matched case-insensitively.
+func (s *StoreInfo) MatchesLabels(required map[string]string) bool {
+	for key, value := range required {
+		if !strings.EqualFold(s.GetLabelValue(key), value) {
+			return false
 		}
 	}
-	return -1
+	return true
 }
 
-// MergeLabels merges the passed in labels with origins, overriding duplicated
+// LabelsFingerprint returns a stable hash of the label set of the store. The
+// order and the case of the labels do not matter.
+func (s *StoreInfo) LabelsFingerprint() uint64 {
+	return s.labelsFingerprint
+}
+
+func labelsFingerprint(labels []*metapb.StoreLabel) uint64 {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, strings.ToLower(label.GetKey())+"="+strings.ToLower(label.GetValue()))
+	}
+	sort.Strings(pairs)
+	h := fnv.New64a()
+	for _, pair := range pairs {
+		h.Write([]byte(pair))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+const (
+	// EngineKey is the label key of the store's storage engine.
+	EngineKey = "engine"
+	// EngineTiFlash is the label value of TiFlash stores.
+	EngineTiFlash = "tiflash"
+)
+
+// GetEngine returns the storage engine of the store. It is empty for regular
+// TiKV stores.
+func (s *StoreInfo) GetEngine() string {
+	return s.GetLabelValue(EngineKey)
+}
+
+// IsTiFlash checks if the store is a TiFlash store.
+func (s *StoreInfo) IsTiFlash() bool {
+	return strings.EqualFold(s.GetEngine(), EngineTiFlash)
+}
+
+const (
+	// DiskKey is the label key of the store's disk type.
+	DiskKey = "disk"
+	// Disk types of stores.
+	DiskSSD  = "ssd"
+	DiskHDD  = "hdd"
+	DiskNVMe = "nvme"
+)
+
+// DiskType returns the disk type of the store in lower case, it is empty if
+// the store is not labeled.
+func (s *StoreInfo) DiskType() string {
+	return strings.ToLower(s.GetLabelValue(DiskKey))
+}
+
+// IsSSD checks if the store is on solid state disks, including NVMe ones.
+func (s *StoreInfo) IsSSD() bool {
+	diskType := s.DiskType()
+	return diskType == DiskSSD || diskType == DiskNVMe
+}
+
+// CompareLocation compares 2 stores' labels and returns at which level their
+// locations are different. It returns -1 if they are at the same location.
+func (s *StoreInfo) CompareLocation(other *StoreInfo, labels []string) int {
+	for i, key := range labels {
+		v1, v2 := s.GetLabelValue(key), other.GetLabelValue(key)
+		// If label is not set, the store is considered at the same location
+		// with any other store.
+		if v1 != "" && v2 != "" && !strings.EqualFold(v1, v2) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CompareLocationLabel works like CompareLocation, but returns the label key
+// at which the 2 stores' locations are different. The returned bool is false
+// if they are at the same location.
+func (s *StoreInfo) CompareLocationLabel(other *StoreInfo, labels []string) (string, bool) {
+	if i := s.CompareLocation(other, labels); i != -1 {
+		return labels[i], true
+	}
+	return "", false
+}
+
+// LocationDistance returns the sum of weights of the label levels at which
+// the 2 stores' locations are different. Every level has weight 1 if the
+// length of weights does not match labels.
+func (s *StoreInfo) LocationDistance(other *StoreInfo, labels []string, weights []float64) float64 {
+	var distance float64
+	for i, key := range labels {
+		v1, v2 := s.GetLabelValue(key), other.GetLabelValue(key)
+		if v1 == "" || v2 == "" || strings.EqualFold(v1, v2) {
+			continue
+		}
+		if len(weights) == len(labels) {
+			distance += weights[i]
+		} else {
+			distance++
+		}
+	}
+	return distance
+}
+
+// StoresViolateAntiAffinity returns true if any 2 of the stores are at the
+// same location of labelKey. Like CompareLocation, a store without the label
+// is considered at the same location with any other store.
+func StoresViolateAntiAffinity(stores []*StoreInfo, labelKey string) bool {
+	labels := []string{labelKey}
+	for i, s := range stores {
+		for _, other := range stores[i+1:] {
+			if s.CompareLocation(other, labels) == -1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StoresSatisfyAffinity returns true if all the stores are at the same
+// location of labelKey. Like CompareLocation, a store without the label is
+// considered at the same location with any other store.
+func StoresSatisfyAffinity(stores []*StoreInfo, labelKey string) bool {
+	labels := []string{labelKey}
+	for i, s := range stores {
+		for _, other := range stores[i+1:] {
+			if s.CompareLocation(other, labels) != -1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// StoreIsolationLevel returns the label level at which every pair of the
+// stores is isolated, which is the highest level returned by CompareLocation
+// among all pairs. It returns -1 if any two stores are at the same location,
+// and len(labels) if there are less than 2 stores.
+func StoreIsolationLevel(stores []*StoreInfo, labels []string) int {
+	if len(stores) < 2 {
+		return len(labels)
+	}
+	level := 0
+	for i := range stores {
+		for j := i + 1; j < len(stores); j++ {
+			l := stores[i].CompareLocation(stores[j], labels)
+			if l == -1 {
+				return -1
+			}
+			if l > level {
+				level = l
+			}
+		}
+	}
+	return level
+}
+
+// MergeLabels merges the passed in labels with origins, overriding duplicated
 // ones.
 func (s *StoreInfo) MergeLabels(labels []*metapb.StoreLabel) []*metapb.StoreLabel {
 	storeLabels := s.GetLabels()
@@ -449,6 +1514,106 @@ L:
 	return storeLabels
 }
 
+const maxLabelKeyLength = 64
+
+var labelKeyRule = regexp.MustCompile("^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$")
+
+// validateLabels checks the passed in labels before they are merged into the
+// store's labels. Required keys must not be removed by setting them to empty.
+func (s *StoreInfo) validateLabels(labels []*metapb.StoreLabel, requiredKeys []string) errcode.ErrorCode {
+	for _, label := range labels {
+		key := label.GetKey()
+		if key == "" {
+			return StoreLabelInvalidErr{StoreID: s.GetID(), Key: key, Reason: "empty key"}
+		}
+		if len(key) > maxLabelKeyLength {
+			return StoreLabelInvalidErr{StoreID: s.GetID(), Key: key, Reason: "key is too long"}
+		}
+		if !labelKeyRule.MatchString(key) {
+			return StoreLabelInvalidErr{StoreID: s.GetID(), Key: key, Reason: "key contains invalid characters"}
+		}
+		if label.GetValue() != "" {
+			continue
+		}
+		for _, required := range requiredKeys {
+			if strings.EqualFold(key, required) {
+				return StoreLabelInvalidErr{StoreID: s.GetID(), Key: key, Reason: "required key cannot be removed"}
+			}
+		}
+	}
+	return nil
+}
+
+// cloneMergedLabels works like MergeLabels, but leaves the labels of the
+// store untouched.
+func (s *StoreInfo) cloneMergedLabels(labels []*metapb.StoreLabel) []*metapb.StoreLabel {
+	storeLabels := make([]*metapb.StoreLabel, 0, len(s.GetLabels())+len(labels))
+	for _, label := range s.GetLabels() {
+		storeLabels = append(storeLabels, &metapb.StoreLabel{Key: label.GetKey(), Value: label.GetValue()})
+	}
+L:
+	for _, newLabel := range labels {
+		for _, label := range storeLabels {
+			if strings.EqualFold(label.Key, newLabel.Key) {
+				label.Value = newLabel.Value
+				continue L
+			}
+		}
+		storeLabels = append(storeLabels, &metapb.StoreLabel{Key: newLabel.GetKey(), Value: newLabel.GetValue()})
+	}
+	return storeLabels
+}
+
+// withDefaultLabels returns the store with the default labels whose keys are
+// missing in the store's labels. Present labels are never overridden.
+func (s *StoreInfo) withDefaultLabels(defaults []*metapb.StoreLabel) *StoreInfo {
+	var missing []*metapb.StoreLabel
+L:
+	for _, label := range defaults {
+		for _, storeLabel := range s.GetLabels() {
+			if strings.EqualFold(storeLabel.GetKey(), label.GetKey()) {
+				continue L
+			}
+		}
+		missing = append(missing, label)
+	}
+	if len(missing) == 0 {
+		return s
+	}
+	return s.Clone(SetStoreLabels(s.cloneMergedLabels(missing)))
+}
+
+// ExportMetrics emits the metrics of the store, labeled by the store ID.
+func (s *StoreInfo) ExportMetrics(emit func(name string, value float64, labels map[string]string)) {
+	labels := map[string]string{"store": fmt.Sprintf("%d", s.GetID())}
+	stats := s.GetRollingStoreStats()
+	emit("region_size", float64(s.GetRegionSize()), labels)
+	emit("region_count", float64(s.GetRegionCount()), labels)
+	emit("leader_size", float64(s.GetLeaderSize()), labels)
+	emit("leader_count", float64(s.GetLeaderCount()), labels)
+	emit("pending_peer_count", float64(s.GetPendingPeerCount()), labels)
+	emit("store_available", float64(s.GetAvailable()), labels)
+	emit("store_used", float64(s.GetUsedSize()), labels)
+	emit("store_capacity", float64(s.GetCapacity()), labels)
+	emit("available_ratio", s.AvailableRatio(), labels)
+	emit("bytes_write_rate", stats.GetBytesWriteRate(), labels)
+	emit("bytes_read_rate", stats.GetBytesReadRate(), labels)
+	emit("keys_write_rate", stats.GetKeysWriteRate(), labels)
+	emit("keys_read_rate", stats.GetKeysReadRate(), labels)
+}
+
+// LogFields returns the structured fields to identify the store in logs.
+func (s *StoreInfo) LogFields() log.Fields {
+	return log.Fields{
+		"store_id":        s.GetID(),
+		"state":           s.GetState().String(),
+		"address":         s.GetAddress(),
+		"region_count":    s.GetRegionCount(),
+		"leader_count":    s.GetLeaderCount(),
+		"available_ratio": s.AvailableRatio(),
+	}
+}
+
 // StoreHotRegionInfos : used to get human readable description for hot regions.
 type StoreHotRegionInfos struct {
 	AsPeer   StoreHotRegionsStat `json:"as_peer"`
@@ -471,18 +1636,127 @@ func NewStoreNotFoundErr(storeID uint64) errcode.ErrorCode {
 	return errcode.NewNotFoundErr(storeNotFoundErr{storeID})
 }
 
-// StoresInfo contains information about all stores.
+// StoreStateChangeHandler is called when the state of a store changes.
+type StoreStateChangeHandler func(storeID uint64, oldState, newState metapb.StoreState)
+
+// StoresInfo contains information about all stores. It is not safe for
+// concurrent use, the caller must hold a lock such as the one of the cluster.
 type StoresInfo struct {
-	stores         map[uint64]*StoreInfo
-	bytesReadRate  float64
-	bytesWriteRate float64
-}
+	stores             map[uint64]*StoreInfo
+	bytesReadRate      float64
+	bytesWriteRate     float64
+	stateChangeHandler StoreStateChangeHandler
+	highSpaceRatio     float64
+	lowSpaceRatio      float64
+	// offlineRegionCounts are the region counts of the offline stores at the
+	// moment they became offline.
+	offlineRegionCounts map[uint64]int
+	// labelDefaults are applied to the stores missing the label keys when
+	// they are first added.
+	labelDefaults []*metapb.StoreLabel
+	// protectedLabelKeys are the label keys refused to be removed by
+	// RemoveStoreLabels.
+	protectedLabelKeys []string
+	// scorer is used by ScoreStore and ScoreAll, nil means the region score
+	// with the space ratios of the StoresInfo.
+	scorer StoreScorer
+}
+
+const (
+	defaultHighSpaceRatio = 0.6
+	defaultLowSpaceRatio  = 0.8
+)
 
 // NewStoresInfo create a StoresInfo with map of storeID to StoreInfo
 func NewStoresInfo() *StoresInfo {
 	return &StoresInfo{
-		stores: make(map[uint64]*StoreInfo),
+		stores:              make(map[uint64]*StoreInfo),
+		highSpaceRatio:      defaultHighSpaceRatio,
+		lowSpaceRatio:       defaultLowSpaceRatio,
+		offlineRegionCounts: make(map[uint64]int),
+	}
+}
+
+// SetSpaceRatios sets the space ratios used by RegionScoreOf. The ratios
+// should satisfy 0 < highSpaceRatio < lowSpaceRatio < 1.
+func (s *StoresInfo) SetSpaceRatios(highSpaceRatio, lowSpaceRatio float64) errcode.ErrorCode {
+	if highSpaceRatio <= 0 || lowSpaceRatio >= 1 || highSpaceRatio >= lowSpaceRatio {
+		return errcode.NewInvalidInputErr(errors.Errorf("invalid space ratios: high %v, low %v", highSpaceRatio, lowSpaceRatio))
+	}
+	s.highSpaceRatio, s.lowSpaceRatio = highSpaceRatio, lowSpaceRatio
+	return nil
+}
+
+// GetSpaceRatios returns the space ratios used by RegionScoreOf.
+func (s *StoresInfo) GetSpaceRatios() (highSpaceRatio, lowSpaceRatio float64) {
+	return s.highSpaceRatio, s.lowSpaceRatio
+}
+
+// RegionScoreOf returns the region score of the store with storeID, using the
+// space ratios of the StoresInfo. It returns 0 if the store is not found.
+func (s *StoresInfo) RegionScoreOf(storeID uint64, delta int64) float64 {
+	store, ok := s.stores[storeID]
+	if !ok {
+		return 0
+	}
+	return store.RegionScore(s.highSpaceRatio, s.lowSpaceRatio, delta)
+}
+
+// StoreScorer scores stores for scheduling.
+type StoreScorer interface {
+	Score(store *StoreInfo) float64
+}
+
+// StoreScorerFunc is an adapter to use a function as a StoreScorer.
+type StoreScorerFunc func(store *StoreInfo) float64
+
+// Score calls f(store).
+func (f StoreScorerFunc) Score(store *StoreInfo) float64 {
+	return f(store)
+}
+
+// RegionScorer scores stores by RegionScore with zero delta.
+type RegionScorer struct {
+	HighSpaceRatio float64
+	LowSpaceRatio  float64
+}
+
+// Score returns the region score of the store.
+func (r RegionScorer) Score(store *StoreInfo) float64 {
+	return store.RegionScore(r.HighSpaceRatio, r.LowSpaceRatio, 0)
+}
+
+// SetScorer sets the scorer used by ScoreStore and ScoreAll. A nil scorer
+// restores the default RegionScorer with the space ratios of the StoresInfo.
+func (s *StoresInfo) SetScorer(scorer StoreScorer) {
+	s.scorer = scorer
+}
+
+func (s *StoresInfo) getScorer() StoreScorer {
+	if s.scorer == nil {
+		return RegionScorer{HighSpaceRatio: s.highSpaceRatio, LowSpaceRatio: s.lowSpaceRatio}
+	}
+	return s.scorer
+}
+
+// ScoreStore returns the score of the store with storeID by the scorer. It
+// returns 0 if the store is not found.
+func (s *StoresInfo) ScoreStore(storeID uint64) float64 {
+	store, ok := s.stores[storeID]
+	if !ok {
+		return 0
+	}
+	return s.getScorer().Score(store)
+}
+
+// ScoreAll returns the scores of all stores by the scorer.
+func (s *StoresInfo) ScoreAll() map[uint64]float64 {
+	scorer := s.getScorer()
+	scores := make(map[uint64]float64, len(s.stores))
+	for id, store := range s.stores {
+		scores[id] = scorer.Score(store)
 	}
+	return scores
 }
 
 // GetStore returns a copy of the StoreInfo with the specified storeID.
@@ -503,12 +1777,158 @@ func (s *StoresInfo) TakeStore(storeID uint64) *StoreInfo {
 	return store
 }
 
+// Clone creates a copy of current StoresInfo which can be handed off to
+// another goroutine. The StoreInfos are cloned, but they still share the
+// rolling statistics with the origin, which are safe for concurrent use.
+// The state change handler is not copied, so updating the clone does not
+// notify the owner of the origin.
+func (s *StoresInfo) Clone() *StoresInfo {
+	stores := make(map[uint64]*StoreInfo, len(s.stores))
+	for id, store := range s.stores {
+		stores[id] = store.Clone()
+	}
+	offlineRegionCounts := make(map[uint64]int, len(s.offlineRegionCounts))
+	for id, count := range s.offlineRegionCounts {
+		offlineRegionCounts[id] = count
+	}
+	return &StoresInfo{
+		stores:              stores,
+		bytesReadRate:       s.bytesReadRate,
+		bytesWriteRate:      s.bytesWriteRate,
+		highSpaceRatio:      s.highSpaceRatio,
+		lowSpaceRatio:       s.lowSpaceRatio,
+		offlineRegionCounts: offlineRegionCounts,
+		labelDefaults:       s.labelDefaults,
+		protectedLabelKeys:  s.protectedLabelKeys,
+		scorer:              s.scorer,
+	}
+}
+
+// SetStateChangeHandler sets the handler which is called when SetStore
+// changes the state of an existing store.
+func (s *StoresInfo) SetStateChangeHandler(handler StoreStateChangeHandler) {
+	s.stateChangeHandler = handler
+}
+
+// SetLabelDefaults sets the default labels, which are applied to the stores
+// missing the label keys when they are first added by SetStore.
+func (s *StoresInfo) SetLabelDefaults(defaults []*metapb.StoreLabel) {
+	s.labelDefaults = make([]*metapb.StoreLabel, 0, len(defaults))
+	for _, label := range defaults {
+		s.labelDefaults = append(s.labelDefaults, &metapb.StoreLabel{Key: label.GetKey(), Value: label.GetValue()})
+	}
+}
+
 // SetStore sets a StoreInfo with storeID.
 func (s *StoresInfo) SetStore(store *StoreInfo) {
-	s.stores[store.GetID()] = store
-	store.GetRollingStoreStats().Observe(store.GetStoreStats())
+	s.notifyStateChange(s.setStore(store), store)
+}
+
+// CompareAndSetStore sets the store only if the current store with the same
+// ID is expected, which is nil if the store should not exist yet. It returns
+// false if the store has been changed by others, so the caller can retry with
+// the latest store. The caller only needs to hold the write lock for the call
+// itself, rather than while building the new store from expected.
+func (s *StoresInfo) CompareAndSetStore(expected, store *StoreInfo) bool {
+	if s.stores[store.GetID()] != expected {
+		return false
+	}
+	s.SetStore(store)
+	return true
+}
+
+// setStore puts the store and returns the replaced store if the state is
+// changed.
+func (s *StoresInfo) setStore(store *StoreInfo) *StoreInfo {
+	if old, ok := s.stores[store.GetID()]; !ok && len(s.labelDefaults) > 0 {
+		store = store.withDefaultLabels(s.labelDefaults)
+	} else if ok && old.GetState() != store.GetState() {
+		store = store.Clone(setStateTransitionTime(now()))
+	}
+	old := s.putStore(store)
 	s.updateTotalBytesReadRate()
 	s.updateTotalBytesWriteRate()
+	if old == nil || old.GetState() == store.GetState() {
+		return nil
+	}
+	s.updateOfflineRegionCount(old, store)
+	store.RecordEvent(StoreEventStateChange, fmt.Sprintf("%s -> %s", old.GetState(), store.GetState()))
+	return old
+}
+
+func (s *StoresInfo) notifyStateChange(old, store *StoreInfo) {
+	if old != nil && s.stateChangeHandler != nil {
+		s.stateChangeHandler(store.GetID(), old.GetState(), store.GetState())
+	}
+}
+
+// setStateTransitionTime records now as the time when the store transitions
+// to its current state.
+func setStateTransitionTime(now time.Time) StoreCreateOption {
+	return func(store *StoreInfo) {
+		switch store.GetState() {
+		case metapb.StoreState_Up:
+			store.upTransitionTime = now
+		case metapb.StoreState_Tombstone:
+			store.tombstoneTime = now
+		}
+	}
+}
+
+func (s *StoresInfo) updateOfflineRegionCount(old, store *StoreInfo) {
+	if store.IsOffline() {
+		s.offlineRegionCounts[store.GetID()] = old.GetRegionCount()
+	} else {
+		delete(s.offlineRegionCounts, store.GetID())
+	}
+}
+
+// GetDecommissionProgress returns the decommission progress of the offline
+// store, based on its region count when it became offline. It returns false
+// if the store is not found or not offline.
+func (s *StoresInfo) GetDecommissionProgress(storeID uint64) (float64, bool) {
+	store, ok := s.stores[storeID]
+	if !ok || !store.IsOffline() {
+		return 0, false
+	}
+	initialRegionCount, ok := s.offlineRegionCounts[storeID]
+	if !ok {
+		initialRegionCount = store.GetRegionCount()
+	}
+	return store.DecommissionProgress(initialRegionCount), true
+}
+
+// putStore puts the store into the map and observes its statistics, without
+// updating the total rates. It returns the replaced store if exists.
+func (s *StoresInfo) putStore(store *StoreInfo) *StoreInfo {
+	old := s.stores[store.GetID()]
+	if old != nil && old.IsFrozen() {
+		store = store.Clone(keepFrozenStats(old))
+	} else {
+		store.GetRollingStoreStats().Observe(store.GetStoreStats())
+		// The amplification needs the region size, which the statistics do
+		// not carry.
+		if smoothAmplificationEnabled() && store.GetRegionSize() > 0 && store.GetUsedSize() > 0 {
+			store.GetRollingStoreStats().ObserveAmplification(store.CompressionAmplification())
+		}
+	}
+	s.stores[store.GetID()] = store
+	return old
+}
+
+// keepFrozenStats keeps the statistics and counts of the frozen store.
+func keepFrozenStats(frozen *StoreInfo) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.frozen = true
+		store.stats = frozen.stats
+		store.prevCapacity = frozen.prevCapacity
+		store.rollingStoreStats = frozen.rollingStoreStats
+		store.leaderCount = frozen.leaderCount
+		store.regionCount = frozen.regionCount
+		store.leaderSize = frozen.leaderSize
+		store.regionSize = frozen.regionSize
+		store.pendingPeerCount = frozen.pendingPeerCount
+	}
 }
 
 // BlockStore blocks a StoreInfo with storeID.
@@ -521,7 +1941,124 @@ func (s *StoresInfo) BlockStore(storeID uint64) errcode.ErrorCode {
 	if store.IsBlocked() {
 		return op.AddTo(StoreBlockedErr{StoreID: storeID})
 	}
-	s.stores[storeID] = store.Clone(SetStoreBlock())
+	s.stores[storeID] = store.Clone(SetStoreBlock(), func(store *StoreInfo) {
+		store.blockCount = store.GetBlockCount() + 1
+		store.lastBlockTime = now()
+	})
+	store.RecordEvent(StoreEventBlock, "")
+	return nil
+}
+
+// CheckSchedulable returns the reason why the store cannot be scheduled as
+// an error, or nil if it can. The low space check uses the space ratios set
+// by SetSpaceRatios.
+func (s *StoresInfo) CheckSchedulable(storeID uint64) errcode.ErrorCode {
+	op := errcode.Op("store.schedulable")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	if store.IsTombstone() {
+		return op.AddTo(StoreTombstonedErr{StoreID: storeID})
+	}
+	if store.IsBlocked() {
+		return op.AddTo(StoreBlockedErr{StoreID: storeID})
+	}
+	if store.IsDisconnected() {
+		return op.AddTo(StoreDisconnectedErr{StoreID: storeID})
+	}
+	if store.IsLowSpace(s.lowSpaceRatio) {
+		return op.AddTo(StoreLowSpaceErr{StoreID: storeID})
+	}
+	return nil
+}
+
+// PauseScheduling pauses scheduling to a store for d. Unlike BlockStore, the
+// pause resumes automatically after the deadline.
+func (s *StoresInfo) PauseScheduling(storeID uint64, d time.Duration) errcode.ErrorCode {
+	op := errcode.Op("store.pause")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	s.stores[storeID] = store.Clone(func(store *StoreInfo) {
+		store.pauseUntil = now().Add(d)
+	})
+	return nil
+}
+
+// FreezeStore freezes the statistics and counts of a store, so that they are
+// not changed by SetStore until UnfreezeStore. Other changes such as the
+// state of the store are still accepted.
+func (s *StoresInfo) FreezeStore(storeID uint64) errcode.ErrorCode {
+	op := errcode.Op("store.freeze")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	s.stores[storeID] = store.Clone(SetStoreFrozen(true))
+	return nil
+}
+
+// UnfreezeStore unfreezes the statistics and counts of a store.
+func (s *StoresInfo) UnfreezeStore(storeID uint64) errcode.ErrorCode {
+	op := errcode.Op("store.unfreeze")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	s.stores[storeID] = store.Clone(SetStoreFrozen(false))
+	return nil
+}
+
+// UpdateStoreLabels merges the passed in labels into the labels of the store
+// with storeID. Labels with an invalid key, or removing one of requiredKeys,
+// are rejected and the store is left unchanged.
+func (s *StoresInfo) UpdateStoreLabels(storeID uint64, labels []*metapb.StoreLabel, requiredKeys []string) errcode.ErrorCode {
+	op := errcode.Op("store.labels.update")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	if err := store.validateLabels(labels, requiredKeys); err != nil {
+		return op.AddTo(err)
+	}
+	s.stores[storeID] = store.Clone(SetStoreLabels(store.cloneMergedLabels(labels)))
+	return nil
+}
+
+// SetProtectedLabelKeys sets the label keys which RemoveStoreLabels refuses to
+// remove.
+func (s *StoresInfo) SetProtectedLabelKeys(keys []string) {
+	s.protectedLabelKeys = append([]string(nil), keys...)
+}
+
+// RemoveStoreLabels removes the labels of the keys from a store. The keys are
+// matched case-insensitively, and protected keys cannot be removed.
+func (s *StoresInfo) RemoveStoreLabels(storeID uint64, keys []string) errcode.ErrorCode {
+	op := errcode.Op("store.labels.remove")
+	store, ok := s.stores[storeID]
+	if !ok {
+		return op.AddTo(NewStoreNotFoundErr(storeID))
+	}
+	for _, key := range keys {
+		for _, protected := range s.protectedLabelKeys {
+			if strings.EqualFold(key, protected) {
+				return op.AddTo(StoreLabelInvalidErr{StoreID: storeID, Key: key, Reason: "protected key cannot be removed"})
+			}
+		}
+	}
+	labels := make([]*metapb.StoreLabel, 0, len(store.GetLabels()))
+L:
+	for _, label := range store.GetLabels() {
+		for _, key := range keys {
+			if strings.EqualFold(label.GetKey(), key) {
+				continue L
+			}
+		}
+		labels = append(labels, &metapb.StoreLabel{Key: label.GetKey(), Value: label.GetValue()})
+	}
+	s.stores[storeID] = store.Clone(SetStoreLabels(labels))
 	return nil
 }
 
@@ -532,29 +2069,590 @@ func (s *StoresInfo) UnblockStore(storeID uint64) {
 		log.Fatalf("store %d is unblocked, but it is not found", storeID)
 	}
 	s.stores[storeID] = store.Clone(SetStoreUnBlock())
+	store.RecordEvent(StoreEventUnblock, "")
+}
+
+// ResetStoreStats clears the rolling statistics of a store, which is useful
+// when a store rejoins the cluster and its history is stale.
+func (s *StoresInfo) ResetStoreStats(storeID uint64) {
+	if store, ok := s.stores[storeID]; ok {
+		store.GetRollingStoreStats().ResetStats()
+		s.updateTotalBytesReadRate()
+		s.updateTotalBytesWriteRate()
+	}
+}
+
+// GetStoreStatsHistory returns the raw samples of the rolling statistics of
+// the store, or nil if the store is not found.
+func (s *StoresInfo) GetStoreStatsHistory(storeID uint64) *RollingStoreStatsSnapshot {
+	store, ok := s.stores[storeID]
+	if !ok {
+		return nil
+	}
+	return store.GetRollingStoreStats().Snapshot()
 }
 
 // GetStores gets a complete set of StoreInfo.
 func (s *StoresInfo) GetStores() []*StoreInfo {
 	stores := make([]*StoreInfo, 0, len(s.stores))
 	for _, store := range s.stores {
-		stores = append(stores, store)
+		stores = append(stores, store)
+	}
+	return stores
+}
+
+// ExportAllMetrics emits the metrics of all stores.
+func (s *StoresInfo) ExportAllMetrics(emit func(name string, value float64, labels map[string]string)) {
+	for _, store := range s.stores {
+		store.ExportMetrics(emit)
+	}
+}
+
+// GetExpiredTombstones returns the sorted IDs of the stores which have been
+// Tombstone for longer than maxAge.
+func (s *StoresInfo) GetExpiredTombstones(maxAge time.Duration) []uint64 {
+	var ids []uint64
+	for _, store := range s.stores {
+		if store.TombstonedFor() > maxAge {
+			ids = append(ids, store.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// GetStoresSortedByID gets a complete set of StoreInfo in ascending ID order.
+func (s *StoresInfo) GetStoresSortedByID() []*StoreInfo {
+	stores := s.GetStores()
+	sort.Slice(stores, func(i, j int) bool { return stores[i].GetID() < stores[j].GetID() })
+	return stores
+}
+
+// ForEach calls fn for every store without allocating a slice. fn must not
+// modify the StoresInfo.
+func (s *StoresInfo) ForEach(fn func(*StoreInfo)) {
+	for _, store := range s.stores {
+		fn(store)
+	}
+}
+
+// ForEachUp works like ForEach, but only visits Up stores.
+func (s *StoresInfo) ForEachUp(fn func(*StoreInfo)) {
+	for _, store := range s.stores {
+		if store.IsUp() {
+			fn(store)
+		}
+	}
+}
+
+// GetVersionDistribution returns the IDs of stores grouped by version, in
+// ascending order of ID.
+func (s *StoresInfo) GetVersionDistribution() map[string][]uint64 {
+	distribution := make(map[string][]uint64)
+	for _, store := range s.stores {
+		distribution[store.GetVersion()] = append(distribution[store.GetVersion()], store.GetID())
+	}
+	for _, ids := range distribution {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return distribution
+}
+
+// GetStoresByVersion returns the stores whose version is exactly version.
+func (s *StoresInfo) GetStoresByVersion(version string) []*StoreInfo {
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		if store.GetVersion() == version {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// CountStoresByVersion returns the number of stores of each version.
+func (s *StoresInfo) CountStoresByVersion() map[string]int {
+	counts := make(map[string]int)
+	for _, store := range s.stores {
+		counts[store.GetVersion()]++
+	}
+	return counts
+}
+
+// MinClusterVersion returns the lowest version of Up stores. It returns empty
+// if there is no Up store.
+func (s *StoresInfo) MinClusterVersion() string {
+	var minVersion string
+	var found bool
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		if !found || versionLess(store.GetVersion(), minVersion) {
+			minVersion, found = store.GetVersion(), true
+		}
+	}
+	return minVersion
+}
+
+// versionLess compares 2 versions as semantic versions, and falls back to
+// compare them as strings if any of them cannot be parsed.
+func versionLess(a, b string) bool {
+	va, errA := semver.NewVersion(strings.TrimPrefix(a, "v"))
+	vb, errB := semver.NewVersion(strings.TrimPrefix(b, "v"))
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return va.LessThan(*vb)
+}
+
+// GroupByLabel groups all stores by their values of the label. Stores
+// without the label are grouped under empty string.
+func (s *StoresInfo) GroupByLabel(labelKey string) map[string][]*StoreInfo {
+	groups := make(map[string][]*StoreInfo)
+	for _, store := range s.stores {
+		value := store.GetLabelValue(labelKey)
+		groups[value] = append(groups[value], store)
+	}
+	return groups
+}
+
+// GroupSpaceBalance returns the coefficient of variation of the available
+// ratio of Up stores in each group of the label. A higher value indicates
+// the space in the group is more imbalanced.
+func (s *StoresInfo) GroupSpaceBalance(labelKey string) map[string]float64 {
+	balance := make(map[string]float64)
+	for value, stores := range s.GroupByLabel(labelKey) {
+		ratios := make([]float64, 0, len(stores))
+		for _, store := range stores {
+			if store.IsUp() {
+				ratios = append(ratios, store.AvailableRatio())
+			}
+		}
+		if len(ratios) == 0 {
+			continue
+		}
+		mean, _ := stats.Mean(ratios)
+		if len(ratios) == 1 || mean == 0 {
+			balance[value] = 0
+			continue
+		}
+		stddev, _ := stats.StandardDeviationPopulation(ratios)
+		balance[value] = stddev / mean
+	}
+	return balance
+}
+
+// SpaceStat is the summed space of a group of stores.
+type SpaceStat struct {
+	Capacity  uint64 `json:"capacity"`
+	Available uint64 `json:"available"`
+	Used      uint64 `json:"used"`
+}
+
+// SpaceByLabel returns the summed space of Up stores in each group of the
+// label. Stores without the label are grouped under empty string.
+func (s *StoresInfo) SpaceByLabel(labelKey string) map[string]SpaceStat {
+	space := make(map[string]SpaceStat)
+	for value, stores := range s.GroupByLabel(labelKey) {
+		for _, store := range stores {
+			if !store.IsUp() {
+				continue
+			}
+			stat := space[value]
+			stat.Capacity += store.GetCapacity()
+			stat.Available += store.GetAvailable()
+			stat.Used += store.GetUsedSize()
+			space[value] = stat
+		}
+	}
+	return space
+}
+
+// ZoneRegionSize returns the total region size of the stores whose label
+// labelKey matches labelValue.
+func (s *StoresInfo) ZoneRegionSize(labelKey, labelValue string) int64 {
+	var size int64
+	for _, store := range s.stores {
+		if strings.EqualFold(store.GetLabelValue(labelKey), labelValue) {
+			size += store.GetRegionSize()
+		}
+	}
+	return size
+}
+
+// IsZoneOverQuota checks if the total region size of the stores whose label
+// labelKey matches labelValue exceeds quota.
+func (s *StoresInfo) IsZoneOverQuota(labelKey, labelValue string, quota int64) bool {
+	return s.ZoneRegionSize(labelKey, labelValue) > quota
+}
+
+// FindDuplicateAddresses returns the addresses reported by more than one
+// store, mapped to the sorted IDs of those stores. Tombstone stores are
+// ignored, since a new store may reuse the address of a removed one.
+func (s *StoresInfo) FindDuplicateAddresses() map[string][]uint64 {
+	ids := make(map[string][]uint64)
+	for _, store := range s.stores {
+		if store.IsTombstone() || store.GetAddress() == "" {
+			continue
+		}
+		ids[store.GetAddress()] = append(ids[store.GetAddress()], store.GetID())
+	}
+	duplicates := make(map[string][]uint64)
+	for address, storeIDs := range ids {
+		if len(storeIDs) > 1 {
+			sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+			duplicates[address] = storeIDs
+		}
+	}
+	return duplicates
+}
+
+// SelectStores gets all stores which have all the required labels.
+func (s *StoresInfo) SelectStores(required map[string]string) []*StoreInfo {
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		if store.MatchesLabels(required) {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// StoreWithMostHeadroom returns the Up store with the most available bytes,
+// or nil if there is no Up store. Ties are broken by the lower store ID.
+func (s *StoresInfo) StoreWithMostHeadroom() *StoreInfo {
+	var best *StoreInfo
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		if best == nil || store.Headroom() > best.Headroom() ||
+			(store.Headroom() == best.Headroom() && store.GetID() < best.GetID()) {
+			best = store
+		}
+	}
+	return best
+}
+
+// MostSnapshotPressuredStore returns the Up store with the highest snapshot
+// pressure, or nil if there is no Up store. Ties are broken by the lower
+// store ID.
+func (s *StoresInfo) MostSnapshotPressuredStore() *StoreInfo {
+	var most *StoreInfo
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		if most == nil || store.SnapshotPressure() > most.SnapshotPressure() ||
+			(store.SnapshotPressure() == most.SnapshotPressure() && store.GetID() < most.GetID()) {
+			most = store
+		}
+	}
+	return most
+}
+
+// HottestReadStore returns the Up store with the highest bytes read rate, or
+// nil if there is no Up store. Ties are broken by the lower store ID.
+func (s *StoresInfo) HottestReadStore() *StoreInfo {
+	var hottest *StoreInfo
+	var hottestRate float64
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		rate := store.GetRollingStoreStats().GetBytesReadRate()
+		if hottest == nil || rate > hottestRate || (rate == hottestRate && store.GetID() < hottest.GetID()) {
+			hottest, hottestRate = store, rate
+		}
+	}
+	return hottest
+}
+
+// RecentlyActiveStores returns the stores which have split or merged regions
+// within the window.
+func (s *StoresInfo) RecentlyActiveStores(window time.Duration) []*StoreInfo {
+	recent := func(ts time.Time) bool {
+		return !ts.IsZero() && since(ts) <= window
+	}
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		stats := store.GetRollingStoreStats()
+		if recent(stats.GetLastSplitTS()) || recent(stats.GetLastMergeTS()) {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// GetStoresByDiskType gets all stores of the disk type.
+func (s *StoresInfo) GetStoresByDiskType(diskType string) []*StoreInfo {
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		if strings.EqualFold(store.DiskType(), diskType) {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// GetTiKVStores gets all stores which are not TiFlash stores.
+func (s *StoresInfo) GetTiKVStores() []*StoreInfo {
+	stores := make([]*StoreInfo, 0, len(s.stores))
+	for _, store := range s.stores {
+		if !store.IsTiFlash() {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// GetTiFlashStores gets all TiFlash stores.
+func (s *StoresInfo) GetTiFlashStores() []*StoreInfo {
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		if store.IsTiFlash() {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// StoreLessFunc reports whether store a should sort before store b.
+type StoreLessFunc func(a, b *StoreInfo) bool
+
+// ByRegionScore sorts stores by region score in ascending order.
+func ByRegionScore(highSpaceRatio, lowSpaceRatio float64) StoreLessFunc {
+	return func(a, b *StoreInfo) bool {
+		return a.RegionScore(highSpaceRatio, lowSpaceRatio, 0) < b.RegionScore(highSpaceRatio, lowSpaceRatio, 0)
+	}
+}
+
+// BySimpleRegionScore sorts stores by simple region score in ascending order.
+func BySimpleRegionScore() StoreLessFunc {
+	return func(a, b *StoreInfo) bool {
+		return a.SimpleRegionScore() < b.SimpleRegionScore()
+	}
+}
+
+// ByLeaderScore sorts stores by leader score in ascending order.
+func ByLeaderScore() StoreLessFunc {
+	return func(a, b *StoreInfo) bool {
+		return a.LeaderScore(0) < b.LeaderScore(0)
+	}
+}
+
+// SortStoresBy returns a new slice of all stores sorted by less. Stores which
+// are equal according to less are ordered by ID.
+func (s *StoresInfo) SortStoresBy(less StoreLessFunc) []*StoreInfo {
+	stores := s.GetStores()
+	sort.Slice(stores, func(i, j int) bool { return stores[i].GetID() < stores[j].GetID() })
+	sort.SliceStable(stores, func(i, j int) bool { return less(stores[i], stores[j]) })
+	return stores
+}
+
+// StoresByAvailableRatio returns the Up stores sorted by available ratio, in
+// descending order if descending is true. Stores with zero capacity are
+// always placed last, and ties are ordered by ID.
+func (s *StoresInfo) StoresByAvailableRatio(descending bool) []*StoreInfo {
+	var stores []*StoreInfo
+	for _, store := range s.stores {
+		if store.IsUp() {
+			stores = append(stores, store)
+		}
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		a, b := stores[i], stores[j]
+		if (a.GetCapacity() == 0) != (b.GetCapacity() == 0) {
+			return b.GetCapacity() == 0
+		}
+		if a.AvailableRatio() != b.AvailableRatio() {
+			return (a.AvailableRatio() > b.AvailableRatio()) == descending
+		}
+		return a.GetID() < b.GetID()
+	})
+	return stores
+}
+
+// GetMetaStores gets a complete set of metapb.Store.
+func (s *StoresInfo) GetMetaStores() []*metapb.Store {
+	stores := make([]*metapb.Store, 0, len(s.stores))
+	for _, store := range s.stores {
+		stores = append(stores, store.GetMeta())
+	}
+	return stores
+}
+
+// GetStoreCount returns the total count of storeInfo.
+func (s *StoresInfo) GetStoreCount() int {
+	return len(s.stores)
+}
+
+// StoresWithInvalidWeights returns the sorted IDs of the stores whose leader
+// weight or region weight is not positive. Such weights are clamped to
+// minWeight when scoring, which hides the misconfiguration.
+func (s *StoresInfo) StoresWithInvalidWeights() []uint64 {
+	var ids []uint64
+	for _, store := range s.stores {
+		if store.GetLeaderWeight() <= 0 || store.GetRegionWeight() <= 0 {
+			ids = append(ids, store.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ValidateAllStores returns the sorted IDs of the stores with invalid counts.
+func (s *StoresInfo) ValidateAllStores() []uint64 {
+	var ids []uint64
+	for _, store := range s.stores {
+		if store.HasInvalidCounts() {
+			ids = append(ids, store.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// StoresWithSameTopology returns the sorted IDs of the other stores with the
+// same labels as the store.
+func (s *StoresInfo) StoresWithSameTopology(storeID uint64) []uint64 {
+	store, ok := s.stores[storeID]
+	if !ok {
+		return nil
+	}
+	var ids []uint64
+	for _, other := range s.stores {
+		if other.GetID() != storeID && other.LabelsFingerprint() == store.LabelsFingerprint() {
+			ids = append(ids, other.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// TotalResourceCount returns the total leader/region count of Up stores.
+func (s *StoresInfo) TotalResourceCount(kind ResourceKind) uint64 {
+	var total uint64
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += store.ResourceCount(kind)
+		}
+	}
+	return total
+}
+
+// LeaderShare returns the ratio of the leaders held by the Up store with
+// storeID to the total leaders of Up stores. It returns 0 if the store is not
+// found or not Up, or there is no leader.
+func (s *StoresInfo) LeaderShare(storeID uint64) float64 {
+	return s.resourceShare(storeID, LeaderKind)
+}
+
+// RegionShare returns the ratio of the regions held by the Up store with
+// storeID to the total regions of Up stores. It returns 0 if the store is not
+// found or not Up, or there is no region.
+func (s *StoresInfo) RegionShare(storeID uint64) float64 {
+	return s.resourceShare(storeID, RegionKind)
+}
+
+func (s *StoresInfo) resourceShare(storeID uint64, kind ResourceKind) float64 {
+	store, ok := s.stores[storeID]
+	if !ok || !store.IsUp() {
+		return 0
+	}
+	total := s.TotalResourceCount(kind)
+	if total == 0 {
+		return 0
+	}
+	return float64(store.ResourceCount(kind)) / float64(total)
+}
+
+// TargetRegionCount returns the ideal region count of the Up store with
+// storeID, which is the total region count of Up stores distributed by region
+// weights. It returns 0 if the store is not found or not Up.
+func (s *StoresInfo) TargetRegionCount(storeID uint64) int {
+	store, ok := s.stores[storeID]
+	if !ok || !store.IsUp() {
+		return 0
+	}
+	var sumWeights float64
+	for _, store := range s.stores {
+		if store.IsUp() {
+			sumWeights += store.ResourceWeight(RegionKind)
+		}
+	}
+	total := float64(s.TotalResourceCount(RegionKind))
+	return int(math.Round(total * store.ResourceWeight(RegionKind) / sumWeights))
+}
+
+// RegionCountDeviation returns the region count of the store with storeID
+// minus its TargetRegionCount.
+func (s *StoresInfo) RegionCountDeviation(storeID uint64) int {
+	store, ok := s.stores[storeID]
+	if !ok {
+		return 0
+	}
+	return store.GetRegionCount() - s.TargetRegionCount(storeID)
+}
+
+// TotalResourceSize returns the total leader/region size of Up stores.
+func (s *StoresInfo) TotalResourceSize(kind ResourceKind) int64 {
+	var total int64
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += store.ResourceSize(kind)
+		}
 	}
-	return stores
+	return total
 }
 
-// GetMetaStores gets a complete set of metapb.Store.
-func (s *StoresInfo) GetMetaStores() []*metapb.Store {
-	stores := make([]*metapb.Store, 0, len(s.stores))
+// MeanResourceScore returns the mean leader/region score of Up stores.
+func (s *StoresInfo) MeanResourceScore(kind ResourceKind, highSpaceRatio, lowSpaceRatio float64) float64 {
+	var total float64
+	var count int
 	for _, store := range s.stores {
-		stores = append(stores, store.GetMeta())
+		if store.IsUp() {
+			total += store.ResourceScore(kind, highSpaceRatio, lowSpaceRatio, 0)
+			count++
+		}
 	}
-	return stores
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
 }
 
-// GetStoreCount returns the total count of storeInfo.
-func (s *StoresInfo) GetStoreCount() int {
-	return len(s.stores)
+// LeaderBalanceRatio returns the ratio of the minimum leader count to the
+// maximum leader count among the up stores. 1.0 means perfectly balanced.
+func (s *StoresInfo) LeaderBalanceRatio() float64 {
+	return s.balanceRatio((*StoreInfo).GetLeaderCount)
+}
+
+// RegionBalanceRatio returns the ratio of the minimum region count to the
+// maximum region count among the up stores. 1.0 means perfectly balanced.
+func (s *StoresInfo) RegionBalanceRatio() float64 {
+	return s.balanceRatio((*StoreInfo).GetRegionCount)
+}
+
+func (s *StoresInfo) balanceRatio(count func(*StoreInfo) int) float64 {
+	var upCount, minCount, maxCount int
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		n := count(store)
+		if upCount == 0 || n < minCount {
+			minCount = n
+		}
+		if upCount == 0 || n > maxCount {
+			maxCount = n
+		}
+		upCount++
+	}
+	if upCount < 2 || maxCount == 0 {
+		return 1.0
+	}
+	return float64(minCount) / float64(maxCount)
 }
 
 // SetLeaderCount sets the leader count to a storeInfo.
@@ -592,6 +2690,70 @@ func (s *StoresInfo) SetRegionSize(storeID uint64, regionSize int64) {
 	}
 }
 
+// AutoTuneRegionWeights sets the region weight of each Up store proportional
+// to its capacity relative to the largest Up store. Leader weights are left
+// untouched.
+func (s *StoresInfo) AutoTuneRegionWeights() {
+	var maxCapacity uint64
+	for _, store := range s.stores {
+		if store.IsUp() && store.GetCapacity() > maxCapacity {
+			maxCapacity = store.GetCapacity()
+		}
+	}
+	if maxCapacity == 0 {
+		return
+	}
+	for id, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		weight := float64(store.GetCapacity()) / float64(maxCapacity)
+		weight = math.Min(math.Max(weight, minWeight), 1.0)
+		s.stores[id] = store.Clone(SetRegionWeight(weight))
+	}
+}
+
+// SetRegionWeightByLabel sets the region weight of all the stores whose label
+// labelKey matches labelValue, and returns the number of the stores affected.
+// The weight must be positive and finite.
+func (s *StoresInfo) SetRegionWeightByLabel(labelKey, labelValue string, weight float64) (int, errcode.ErrorCode) {
+	if weight <= 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0, errcode.NewInvalidInputErr(errors.Errorf("invalid region weight: %v", weight))
+	}
+	var count int
+	for id, store := range s.stores {
+		if strings.EqualFold(store.GetLabelValue(labelKey), labelValue) {
+			s.stores[id] = store.Clone(SetRegionWeight(weight))
+			count++
+		}
+	}
+	return count, nil
+}
+
+// NormalizedRegionScores returns the region scores of Up stores linearly
+// mapped to [0, 1] by the minimum and maximum score. All stores get 0.5 if
+// their scores are the same.
+func (s *StoresInfo) NormalizedRegionScores(highSpaceRatio, lowSpaceRatio float64) map[uint64]float64 {
+	scores := make(map[uint64]float64)
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, store := range s.stores {
+		if !store.IsUp() {
+			continue
+		}
+		score := store.RegionScore(highSpaceRatio, lowSpaceRatio, 0)
+		scores[store.GetID()] = score
+		lowest, highest = math.Min(lowest, score), math.Max(highest, score)
+	}
+	for id, score := range scores {
+		if highest == lowest {
+			scores[id] = 0.5
+		} else {
+			scores[id] = (score - lowest) / (highest - lowest)
+		}
+	}
+	return scores
+}
+
 // UpdateStoreStatusLocked updates the information of the store.
 func (s *StoresInfo) UpdateStoreStatusLocked(storeID uint64, leaderCount int, regionCount int, pendingPeerCount int, leaderSize int64, regionSize int64) {
 	if store, ok := s.stores[storeID]; ok {
@@ -600,10 +2762,42 @@ func (s *StoresInfo) UpdateStoreStatusLocked(storeID uint64, leaderCount int, re
 			SetPendingPeerCount(pendingPeerCount),
 			SetLeaderSize(leaderSize),
 			SetRegionSize(regionSize))
+		if !store.IsFrozen() {
+			newStore.GetRollingStoreStats().ObservePendingPeerCount(pendingPeerCount)
+		}
 		s.SetStore(newStore)
 	}
 }
 
+// StoreStatusUpdate contains the status to update of a store.
+type StoreStatusUpdate struct {
+	StoreID          uint64
+	LeaderCount      int
+	RegionCount      int
+	PendingPeerCount int
+	LeaderSize       int64
+	RegionSize       int64
+}
+
+// BatchUpdateStoreStatus works like calling UpdateStoreStatusLocked for each
+// update, but only updates the total rates once.
+func (s *StoresInfo) BatchUpdateStoreStatus(updates []StoreStatusUpdate) {
+	for _, update := range updates {
+		if store, ok := s.stores[update.StoreID]; ok {
+			if !store.IsFrozen() {
+				store.GetRollingStoreStats().ObservePendingPeerCount(update.PendingPeerCount)
+			}
+			s.putStore(store.Clone(SetLeaderCount(update.LeaderCount),
+				SetRegionCount(update.RegionCount),
+				SetPendingPeerCount(update.PendingPeerCount),
+				SetLeaderSize(update.LeaderSize),
+				SetRegionSize(update.RegionSize)))
+		}
+	}
+	s.updateTotalBytesReadRate()
+	s.updateTotalBytesWriteRate()
+}
+
 func (s *StoresInfo) updateTotalBytesWriteRate() {
 	var totalBytesWirteRate float64
 	for _, s := range s.stores {
@@ -634,6 +2828,312 @@ func (s *StoresInfo) TotalBytesReadRate() float64 {
 	return s.bytesReadRate
 }
 
+// StoresExceedingAmplification returns the sorted IDs of Up stores whose
+// compression amplification exceeds threshold, which usually indicates
+// compaction is behind.
+func (s *StoresInfo) StoresExceedingAmplification(threshold float64) []uint64 {
+	var ids []uint64
+	for _, store := range s.stores {
+		if store.IsUp() && store.CompressionAmplification() > threshold {
+			ids = append(ids, store.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ClusterAverageRegionSize returns the average size of the regions in Up
+// stores, it is 0 if they have no region.
+func (s *StoresInfo) ClusterAverageRegionSize() int64 {
+	var size, count int64
+	for _, store := range s.stores {
+		if store.IsUp() {
+			size += store.GetRegionSize()
+			count += int64(store.GetRegionCount())
+		}
+	}
+	if count <= 0 {
+		return 0
+	}
+	return size / count
+}
+
+// OversizedStores returns the sorted IDs of Up stores whose region size is
+// greater than factor times the mean region size of Up stores. It returns nil
+// if there are fewer than two Up stores.
+func (s *StoresInfo) OversizedStores(factor float64) []uint64 {
+	var total int64
+	var count int
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += store.GetRegionSize()
+			count++
+		}
+	}
+	if count < 2 {
+		return nil
+	}
+	mean := float64(total) / float64(count)
+	var ids []uint64
+	for _, store := range s.stores {
+		if store.IsUp() && float64(store.GetRegionSize()) > factor*mean {
+			ids = append(ids, store.GetID())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// MeanReadWriteRatio returns the mean read/write ratio of Up stores.
+func (s *StoresInfo) MeanReadWriteRatio() float64 {
+	var total float64
+	var count int
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += store.ReadWriteRatio()
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// TotalBytesWritten returns the total bytes written of all Up stores during
+// their last reporting periods.
+func (s *StoresInfo) TotalBytesWritten() uint64 {
+	return s.sumUpStores((*StoreInfo).GetBytesWritten)
+}
+
+// TotalBytesRead returns the total bytes read of all Up stores during their
+// last reporting periods.
+func (s *StoresInfo) TotalBytesRead() uint64 {
+	return s.sumUpStores((*StoreInfo).GetBytesRead)
+}
+
+// TotalKeysWritten returns the total keys written of all Up stores during
+// their last reporting periods.
+func (s *StoresInfo) TotalKeysWritten() uint64 {
+	return s.sumUpStores((*StoreInfo).GetKeysWritten)
+}
+
+// TotalKeysRead returns the total keys read of all Up stores during their
+// last reporting periods.
+func (s *StoresInfo) TotalKeysRead() uint64 {
+	return s.sumUpStores((*StoreInfo).GetKeysRead)
+}
+
+func (s *StoresInfo) sumUpStores(get func(*StoreInfo) uint64) uint64 {
+	var total uint64
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += get(store)
+		}
+	}
+	return total
+}
+
+// GetHotWriteStores returns the IDs of Up stores whose bytes write rate is
+// greater than factor times the mean bytes write rate of all Up stores.
+func (s *StoresInfo) GetHotWriteStores(factor float64) []uint64 {
+	return s.getHotStores(factor, func(r *RollingStoreStats) float64 { return r.GetBytesWriteRate() })
+}
+
+// GetHotReadStores returns the IDs of Up stores whose bytes read rate is
+// greater than factor times the mean bytes read rate of all Up stores.
+func (s *StoresInfo) GetHotReadStores(factor float64) []uint64 {
+	return s.getHotStores(factor, func(r *RollingStoreStats) float64 { return r.GetBytesReadRate() })
+}
+
+func (s *StoresInfo) getHotStores(factor float64, rate func(*RollingStoreStats) float64) []uint64 {
+	var total float64
+	var count int
+	for _, store := range s.stores {
+		if store.IsUp() {
+			total += rate(store.GetRollingStoreStats())
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	threshold := factor * total / float64(count)
+	var hotStores []uint64
+	for _, store := range s.stores {
+		if store.IsUp() && rate(store.GetRollingStoreStats()) > threshold {
+			hotStores = append(hotStores, store.GetID())
+		}
+	}
+	return hotStores
+}
+
+// ClusterHealth summarizes the health of all stores in a cluster.
+type ClusterHealth struct {
+	Total        int `json:"total"`
+	Up           int `json:"up"`
+	Offline      int `json:"offline"`
+	Tombstone    int `json:"tombstone"`
+	Disconnected int `json:"disconnected"`
+	Unhealthy    int `json:"unhealthy"`
+	LowSpace     int `json:"low_space"`
+	Busy         int `json:"busy"`
+	// InvalidSpace is the count of stores with inconsistent space accounting.
+	InvalidSpace int `json:"invalid_space"`
+}
+
+// HealthSummary returns the health summary of all stores. Tombstone stores
+// are only counted in Total and Tombstone.
+func (s *StoresInfo) HealthSummary(highSpaceRatio, lowSpaceRatio float64) ClusterHealth {
+	var health ClusterHealth
+	for _, store := range s.stores {
+		health.Total++
+		switch store.GetState() {
+		case metapb.StoreState_Up:
+			health.Up++
+		case metapb.StoreState_Offline:
+			health.Offline++
+		case metapb.StoreState_Tombstone:
+			health.Tombstone++
+			continue
+		}
+		if store.IsDisconnected() {
+			health.Disconnected++
+		}
+		if store.IsUnhealth() {
+			health.Unhealthy++
+		}
+		if store.IsLowSpace(lowSpaceRatio) {
+			health.LowSpace++
+		}
+		if store.GetIsBusy() {
+			health.Busy++
+		}
+		if !store.SpaceAccountingValid() {
+			health.InvalidSpace++
+		}
+	}
+	return health
+}
+
+// ScoreStats is the summary of the scores of stores.
+type ScoreStats struct {
+	Total  float64 `json:"total"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// StoresStats is the consolidated report of stores. The scores and the space
+// are summarized over Up stores, while the state counts cover all stores.
+type StoresStats struct {
+	RegionScore ScoreStats     `json:"region_score"`
+	LeaderScore ScoreStats     `json:"leader_score"`
+	Capacity    uint64         `json:"capacity"`
+	Available   uint64         `json:"available"`
+	Used        uint64         `json:"used"`
+	StateCounts map[string]int `json:"state_counts"`
+}
+
+// Stats returns the consolidated report of stores, computed in one pass.
+func (s *StoresInfo) Stats(highSpaceRatio, lowSpaceRatio float64) StoresStats {
+	stats := StoresStats{StateCounts: make(map[string]int)}
+	var upCount int
+	var regionSquares, leaderSquares float64
+	for _, store := range s.stores {
+		stats.StateCounts[store.GetState().String()]++
+		if !store.IsUp() {
+			continue
+		}
+		upCount++
+		regionScore := store.RegionScore(highSpaceRatio, lowSpaceRatio, 0)
+		leaderScore := store.LeaderScore(0)
+		stats.RegionScore.Total += regionScore
+		stats.LeaderScore.Total += leaderScore
+		regionSquares += regionScore * regionScore
+		leaderSquares += leaderScore * leaderScore
+		stats.Capacity += store.GetCapacity()
+		stats.Available += store.GetAvailable()
+		stats.Used += store.GetUsedSize()
+	}
+	if upCount > 0 {
+		stats.RegionScore.summarize(regionSquares, upCount)
+		stats.LeaderScore.summarize(leaderSquares, upCount)
+	}
+	return stats
+}
+
+// summarize computes the mean and the population standard deviation from the
+// total, the sum of squares and the count.
+func (s *ScoreStats) summarize(squares float64, count int) {
+	s.Mean = s.Total / float64(count)
+	variance := squares/float64(count) - s.Mean*s.Mean
+	s.StdDev = math.Sqrt(math.Max(variance, 0))
+}
+
+// ImbalanceReport reports the skew of the leader and region scores of stores,
+// where the skew is (max - min) / mean, and the stores holding the extremes.
+type ImbalanceReport struct {
+	LeaderSkew     float64 `json:"leader_skew"`
+	RegionSkew     float64 `json:"region_skew"`
+	MaxLeaderStore uint64  `json:"max_leader_store"`
+	MinLeaderStore uint64  `json:"min_leader_store"`
+	MaxRegionStore uint64  `json:"max_region_store"`
+	MinRegionStore uint64  `json:"min_region_store"`
+}
+
+// ImbalanceReport returns the skew of the leader and region scores of Up
+// stores. It returns an empty report if there are fewer than two Up stores.
+func (s *StoresInfo) ImbalanceReport(highSpaceRatio, lowSpaceRatio float64) ImbalanceReport {
+	var report ImbalanceReport
+	stores := make([]*StoreInfo, 0, len(s.stores))
+	for _, store := range s.stores {
+		if store.IsUp() {
+			stores = append(stores, store)
+		}
+	}
+	if len(stores) < 2 {
+		return report
+	}
+	report.LeaderSkew, report.MinLeaderStore, report.MaxLeaderStore = scoreSkew(stores, func(store *StoreInfo) float64 {
+		return store.LeaderScore(0)
+	})
+	report.RegionSkew, report.MinRegionStore, report.MaxRegionStore = scoreSkew(stores, func(store *StoreInfo) float64 {
+		return store.RegionScore(highSpaceRatio, lowSpaceRatio, 0)
+	})
+	return report
+}
+
+// scoreSkew returns (max - min) / mean of the scores and the IDs of the stores
+// with the min and max scores. Ties are broken by the lower store ID.
+func scoreSkew(stores []*StoreInfo, score func(*StoreInfo) float64) (skew float64, minID, maxID uint64) {
+	var total, lowest, highest float64
+	for i, store := range stores {
+		v, id := score(store), store.GetID()
+		total += v
+		if i == 0 || v < lowest || (v == lowest && id < minID) {
+			lowest, minID = v, id
+		}
+		if i == 0 || v > highest || (v == highest && id < maxID) {
+			highest, maxID = v, id
+		}
+	}
+	if mean := total / float64(len(stores)); mean != 0 {
+		skew = (highest - lowest) / mean
+	}
+	return skew, minID, maxID
+}
+
+// CountByHotness returns the count of Up stores in each hotness class.
+func (s *StoresInfo) CountByHotness(writeThreshold, readThreshold float64) map[HotnessClass]int {
+	counts := make(map[HotnessClass]int)
+	for _, store := range s.stores {
+		if store.IsUp() {
+			counts[store.HotnessClass(writeThreshold, readThreshold)]++
+		}
+	}
+	return counts
+}
+
 // GetStoresBytesWriteStat returns the bytes write stat of all StoreInfo.
 func (s *StoresInfo) GetStoresBytesWriteStat() map[uint64]uint64 {
 	res := make(map[uint64]uint64, len(s.stores))
@@ -677,57 +3177,341 @@ type RollingStoreStats struct {
 	bytesReadRate  *RollingStats
 	keysWriteRate  *RollingStats
 	keysReadRate   *RollingStats
+	// pendingPeerRate is observed from the store update path rather than
+	// the store statistics.
+	pendingPeerRate *RollingStats
+	// splitRate and mergeRate are the region split and merge rates, they are
+	// only observed if the store reports the split and merge counts.
+	splitRate *RollingStats
+	mergeRate *RollingStats
+	// netSendRate and netRecvRate are the network send and receive bytes
+	// rates, they are only observed if the store reports the NIC counters.
+	netSendRate *RollingStats
+	netRecvRate *RollingStats
+	// amplification is the ratio of the region size to the used size, it is
+	// only observed if smoothAmplification is enabled.
+	amplification *RollingStats
+	// outlierFactor is used to reject samples greater than outlierFactor times
+	// the current median. Zero means disabled.
+	outlierFactor float64
+	// lastUpdate is the time when Observe accepted the last statistics.
+	lastUpdate time.Time
+	// lastSplitTS and lastMergeTS are the time when ObserveRegionChurn
+	// observed the last split and merge.
+	lastSplitTS time.Time
+	lastMergeTS time.Time
 }
 
 const storeStatsRollingWindows = 3
 
+// StoreStatsModes are the aggregation modes of each series in RollingStoreStats.
+type StoreStatsModes struct {
+	BytesWrite  AggregationMode
+	BytesRead   AggregationMode
+	KeysWrite   AggregationMode
+	KeysRead    AggregationMode
+	PendingPeer AggregationMode
+}
+
 func newRollingStoreStats() *RollingStoreStats {
+	return NewRollingStoreStats(StoreStatsModes{})
+}
+
+// NewRollingStoreStats creates a RollingStoreStats whose series are
+// summarized by the specified modes.
+func NewRollingStoreStats(modes StoreStatsModes) *RollingStoreStats {
 	return &RollingStoreStats{
-		bytesWriteRate: NewRollingStats(storeStatsRollingWindows),
-		bytesReadRate:  NewRollingStats(storeStatsRollingWindows),
-		keysWriteRate:  NewRollingStats(storeStatsRollingWindows),
-		keysReadRate:   NewRollingStats(storeStatsRollingWindows),
+		bytesWriteRate:  NewRollingStatsWithMode(storeStatsRollingWindows, modes.BytesWrite),
+		bytesReadRate:   NewRollingStatsWithMode(storeStatsRollingWindows, modes.BytesRead),
+		keysWriteRate:   NewRollingStatsWithMode(storeStatsRollingWindows, modes.KeysWrite),
+		keysReadRate:    NewRollingStatsWithMode(storeStatsRollingWindows, modes.KeysRead),
+		pendingPeerRate: NewRollingStatsWithMode(storeStatsRollingWindows, modes.PendingPeer),
+		splitRate:       NewRollingStats(storeStatsRollingWindows),
+		mergeRate:       NewRollingStats(storeStatsRollingWindows),
+		netSendRate:     NewRollingStats(storeStatsRollingWindows),
+		netRecvRate:     NewRollingStats(storeStatsRollingWindows),
+		amplification:   NewRollingStats(storeStatsRollingWindows),
 	}
 }
 
 // Observe records current statistics.
 func (r *RollingStoreStats) Observe(stats *pdpb.StoreStats) {
-	interval := stats.GetInterval().GetEndTimestamp() - stats.GetInterval().GetStartTimestamp()
+	start, end := stats.GetInterval().GetStartTimestamp(), stats.GetInterval().GetEndTimestamp()
+	if end <= start {
+		// The interval is unsigned, a reordered heartbeat or clock skew makes
+		// it wrap around rather than go negative.
+		if end < start {
+			warnInvalidInterval(start, end)
+		}
+		return
+	}
+	interval := end - start
+	r.Lock()
+	defer r.Unlock()
+	r.lastUpdate = now()
+	r.add(r.bytesWriteRate, float64(stats.BytesWritten/interval))
+	r.add(r.bytesReadRate, float64(stats.BytesRead/interval))
+	r.add(r.keysWriteRate, float64(stats.KeysWritten/interval))
+	r.add(r.keysReadRate, float64(stats.KeysRead/interval))
+}
+
+const invalidIntervalWarningInterval = time.Minute
+
+var lastInvalidIntervalWarning int64
+
+// warnInvalidInterval logs the invalid interval at most once per
+// invalidIntervalWarningInterval.
+func warnInvalidInterval(start, end uint64) {
+	ts := now().UnixNano()
+	last := atomic.LoadInt64(&lastInvalidIntervalWarning)
+	if ts-last < int64(invalidIntervalWarningInterval) ||
+		!atomic.CompareAndSwapInt64(&lastInvalidIntervalWarning, last, ts) {
+		return
+	}
+	log.Warnf("skip store statistics with invalid interval [%d, %d]", start, end)
+}
+
+// add adds the sample to rolling stats unless it is rejected as an outlier.
+// Negative samples are clamped to zero.
+func (r *RollingStoreStats) add(stats *RollingStats, n float64) {
+	if n < 0 {
+		n = 0
+	}
+	if r.outlierFactor > 0 {
+		if median := stats.Median(); median > 0 && n > r.outlierFactor*median {
+			return
+		}
+	}
+	stats.Add(n)
+}
+
+// ObservePendingPeerCount records current pending peer count.
+func (r *RollingStoreStats) ObservePendingPeerCount(count int) {
+	r.Lock()
+	defer r.Unlock()
+	r.pendingPeerRate.Add(float64(count))
+}
+
+// ObserveRegionChurn records the region split and merge counts reported in
+// an interval of seconds. It is a no-op if the interval is zero, since the
+// store statistics do not carry the counts yet.
+func (r *RollingStoreStats) ObserveRegionChurn(splitCount, mergeCount, interval uint64) {
+	if interval == 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.splitRate.Add(float64(splitCount) / float64(interval))
+	r.mergeRate.Add(float64(mergeCount) / float64(interval))
+	if splitCount > 0 {
+		r.lastSplitTS = now()
+	}
+	if mergeCount > 0 {
+		r.lastMergeTS = now()
+	}
+}
+
+// ObserveNetworkBytes records the network bytes sent and received in an
+// interval of seconds. It is a no-op if the interval is zero, since the store
+// statistics do not carry the NIC counters yet.
+func (r *RollingStoreStats) ObserveNetworkBytes(sentBytes, recvBytes, interval uint64) {
 	if interval == 0 {
 		return
 	}
 	r.Lock()
 	defer r.Unlock()
-	r.bytesWriteRate.Add(float64(stats.BytesWritten / interval))
-	r.bytesReadRate.Add(float64(stats.BytesRead / interval))
-	r.keysWriteRate.Add(float64(stats.KeysWritten / interval))
-	r.keysReadRate.Add(float64(stats.KeysRead / interval))
+	r.netSendRate.Add(float64(sentBytes) / float64(interval))
+	r.netRecvRate.Add(float64(recvBytes) / float64(interval))
+}
+
+// ObserveAmplification records current amplification.
+func (r *RollingStoreStats) ObserveAmplification(amplification float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.amplification.Add(amplification)
+}
+
+// SetExpiry makes the observed statistics expire after d, so that the rates
+// of a store which stops reporting decay to zero. Zero disables the expiry.
+func (r *RollingStoreStats) SetExpiry(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	for _, stats := range []*RollingStats{r.bytesWriteRate, r.bytesReadRate, r.keysWriteRate, r.keysReadRate} {
+		stats.setExpiry(d)
+	}
+}
+
+// SetOutlierFactor makes Observe drop samples greater than factor times the
+// current median. Zero disables outlier rejection.
+func (r *RollingStoreStats) SetOutlierFactor(factor float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.outlierFactor = factor
+}
+
+func (r *RollingStoreStats) clone() *RollingStoreStats {
+	r.RLock()
+	defer r.RUnlock()
+	return &RollingStoreStats{
+		bytesWriteRate:  r.bytesWriteRate.clone(),
+		bytesReadRate:   r.bytesReadRate.clone(),
+		keysWriteRate:   r.keysWriteRate.clone(),
+		keysReadRate:    r.keysReadRate.clone(),
+		pendingPeerRate: r.pendingPeerRate.clone(),
+		splitRate:       r.splitRate.clone(),
+		mergeRate:       r.mergeRate.clone(),
+		netSendRate:     r.netSendRate.clone(),
+		netRecvRate:     r.netRecvRate.clone(),
+		amplification:   r.amplification.clone(),
+		outlierFactor:   r.outlierFactor,
+		lastUpdate:      r.lastUpdate,
+		lastSplitTS:     r.lastSplitTS,
+		lastMergeTS:     r.lastMergeTS,
+	}
+}
+
+// RollingStoreStatsSnapshot contains the raw samples of the rolling
+// statistics, from the oldest to the newest.
+type RollingStoreStatsSnapshot struct {
+	BytesWriteRate []float64 `json:"bytes_write_rate"`
+	BytesReadRate  []float64 `json:"bytes_read_rate"`
+	KeysWriteRate  []float64 `json:"keys_write_rate"`
+	KeysReadRate   []float64 `json:"keys_read_rate"`
+}
+
+// Snapshot returns a copy of the raw samples of the rolling statistics.
+func (r *RollingStoreStats) Snapshot() *RollingStoreStatsSnapshot {
+	r.RLock()
+	defer r.RUnlock()
+	return &RollingStoreStatsSnapshot{
+		BytesWriteRate: r.bytesWriteRate.Samples(),
+		BytesReadRate:  r.bytesReadRate.Samples(),
+		KeysWriteRate:  r.keysWriteRate.Samples(),
+		KeysReadRate:   r.keysReadRate.Samples(),
+	}
+}
+
+// ResetStats clears all the recorded statistics.
+func (r *RollingStoreStats) ResetStats() {
+	r.Lock()
+	defer r.Unlock()
+	r.bytesWriteRate.Reset()
+	r.bytesReadRate.Reset()
+	r.keysWriteRate.Reset()
+	r.keysReadRate.Reset()
+	r.pendingPeerRate.Reset()
+	r.splitRate.Reset()
+	r.mergeRate.Reset()
+	r.netSendRate.Reset()
+	r.netRecvRate.Reset()
+	r.amplification.Reset()
+	r.lastUpdate = time.Time{}
+	r.lastSplitTS = time.Time{}
+	r.lastMergeTS = time.Time{}
+}
+
+// GetLastUpdateTime returns the time when the last statistics were accepted.
+func (r *RollingStoreStats) GetLastUpdateTime() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastUpdate
+}
+
+// GetLastSplitTS returns the time when the last split was observed.
+func (r *RollingStoreStats) GetLastSplitTS() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastSplitTS
+}
+
+// GetLastMergeTS returns the time when the last merge was observed.
+func (r *RollingStoreStats) GetLastMergeTS() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lastMergeTS
 }
 
 // GetBytesWriteRate returns the bytes write rate.
 func (r *RollingStoreStats) GetBytesWriteRate() float64 {
 	r.RLock()
 	defer r.RUnlock()
-	return r.bytesWriteRate.Median()
+	return r.bytesWriteRate.Value()
+}
+
+// SampleCount returns the number of the observed statistics in the window.
+func (r *RollingStoreStats) SampleCount() int {
+	r.RLock()
+	defer r.RUnlock()
+	return r.bytesWriteRate.Len()
+}
+
+// GetBytesWriteRateConfident returns the bytes write rate, and false if fewer
+// than minSamples statistics are observed so that the rate is not trustworthy.
+func (r *RollingStoreStats) GetBytesWriteRateConfident(minSamples int) (float64, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.bytesWriteRate.Value(), r.bytesWriteRate.Len() >= minSamples
 }
 
 // GetBytesReadRate returns the bytes read rate.
 func (r *RollingStoreStats) GetBytesReadRate() float64 {
 	r.RLock()
 	defer r.RUnlock()
-	return r.bytesReadRate.Median()
+	return r.bytesReadRate.Value()
 }
 
 // GetKeysWriteRate returns the keys write rate.
 func (r *RollingStoreStats) GetKeysWriteRate() float64 {
 	r.RLock()
 	defer r.RUnlock()
-	return r.keysWriteRate.Median()
+	return r.keysWriteRate.Value()
 }
 
 // GetKeysReadRate returns the keys read rate.
 func (r *RollingStoreStats) GetKeysReadRate() float64 {
 	r.RLock()
 	defer r.RUnlock()
-	return r.keysReadRate.Median()
+	return r.keysReadRate.Value()
+}
+
+// GetSplitRate returns the region split rate.
+func (r *RollingStoreStats) GetSplitRate() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.splitRate.Value()
+}
+
+// GetMergeRate returns the region merge rate.
+func (r *RollingStoreStats) GetMergeRate() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.mergeRate.Value()
+}
+
+// GetNetSendRate returns the network send bytes rate.
+func (r *RollingStoreStats) GetNetSendRate() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.netSendRate.Value()
+}
+
+// GetNetRecvRate returns the network receive bytes rate.
+func (r *RollingStoreStats) GetNetRecvRate() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.netRecvRate.Value()
+}
+
+// GetAmplification returns the amplification.
+func (r *RollingStoreStats) GetAmplification() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.amplification.Value()
+}
+
+// GetPendingPeerRate returns the pending peer count rate.
+func (r *RollingStoreStats) GetPendingPeerRate() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.pendingPeerRate.Value()
 }
@@ -16,6 +16,7 @@ package core
 import (
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 )
@@ -26,28 +27,37 @@ type StoreCreateOption func(region *StoreInfo)
 // SetStoreAddress sets the address for the store.
 func SetStoreAddress(address string) StoreCreateOption {
 	return func(store *StoreInfo) {
-		store.meta.Address = address
+		meta := proto.Clone(store.meta).(*metapb.Store)
+		meta.Address = address
+		store.meta = meta
 	}
 }
 
 // SetStoreLabels sets the labels for the store.
 func SetStoreLabels(labels []*metapb.StoreLabel) StoreCreateOption {
 	return func(store *StoreInfo) {
-		store.meta.Labels = labels
+		meta := proto.Clone(store.meta).(*metapb.Store)
+		meta.Labels = labels
+		store.meta = meta
+		store.labelsFingerprint = labelsFingerprint(labels)
 	}
 }
 
 // SetStoreVersion sets the version for the store.
 func SetStoreVersion(version string) StoreCreateOption {
 	return func(store *StoreInfo) {
-		store.meta.Version = version
+		meta := proto.Clone(store.meta).(*metapb.Store)
+		meta.Version = version
+		store.meta = meta
 	}
 }
 
 // SetStoreState sets the state for the store.
 func SetStoreState(state metapb.StoreState) StoreCreateOption {
 	return func(store *StoreInfo) {
-		store.meta.State = state
+		meta := proto.Clone(store.meta).(*metapb.Store)
+		meta.State = state
+		store.meta = meta
 	}
 }
 
@@ -65,6 +75,29 @@ func SetStoreUnBlock() StoreCreateOption {
 	}
 }
 
+// SetReadOnly marks the store as read-only or not, a read-only store accepts
+// no new peers.
+func SetReadOnly(readOnly bool) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.readOnly = readOnly
+	}
+}
+
+// SetDraining marks the store as draining or not, a draining store accepts no
+// new leaders.
+func SetDraining(draining bool) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.draining = draining
+	}
+}
+
+// SetStoreFrozen freezes or unfreezes the statistics of the store.
+func SetStoreFrozen(frozen bool) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.frozen = frozen
+	}
+}
+
 // SetLeaderCount sets the leader count for the store.
 func SetLeaderCount(leaderCount int) StoreCreateOption {
 	return func(store *StoreInfo) {
@@ -114,9 +147,31 @@ func SetRegionWeight(regionWeight float64) StoreCreateOption {
 	}
 }
 
+// SetAddPeerLimit sets the limit of adding peer operations for the store.
+func SetAddPeerLimit(limit int) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.addPeerLimit = limit
+	}
+}
+
+// SetRemovePeerLimit sets the limit of removing peer operations for the store.
+func SetRemovePeerLimit(limit int) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.removePeerLimit = limit
+	}
+}
+
 // SetLastHeartbeatTS sets the time of last heartbeat for the store.
 func SetLastHeartbeatTS(lastHeartbeatTS time.Time) StoreCreateOption {
 	return func(store *StoreInfo) {
+		if prev := store.lastHeartbeatTS; !prev.IsZero() && lastHeartbeatTS.After(prev) {
+			// Always create a new slice, because it is shared with the origin.
+			intervals := store.heartbeatIntervals
+			if len(intervals) >= heartbeatIntervalWindow {
+				intervals = intervals[len(intervals)-heartbeatIntervalWindow+1:]
+			}
+			store.heartbeatIntervals = append(append([]time.Duration(nil), intervals...), lastHeartbeatTS.Sub(prev))
+		}
 		store.lastHeartbeatTS = lastHeartbeatTS
 	}
 }
@@ -124,6 +179,36 @@ func SetLastHeartbeatTS(lastHeartbeatTS time.Time) StoreCreateOption {
 // SetStoreStats sets the statistics information for the store.
 func SetStoreStats(stats *pdpb.StoreStats) StoreCreateOption {
 	return func(store *StoreInfo) {
+		store.prevCapacity = store.GetCapacity()
 		store.stats = stats
 	}
 }
+
+// SetApplyLatency sets the apply latency for the store.
+func SetApplyLatency(latency time.Duration) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.applyLatency = latency
+	}
+}
+
+// SetCommitLatency sets the commit latency for the store.
+func SetCommitLatency(latency time.Duration) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.commitLatency = latency
+	}
+}
+
+// DeepCloneStats makes the store use a copy of its rolling statistics instead
+// of sharing them, so observing on the store does not affect the origin.
+func DeepCloneStats() StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.rollingStoreStats = store.rollingStoreStats.clone()
+	}
+}
+
+// SetRollingStoreStats sets the rolling statistics for the store.
+func SetRollingStoreStats(rollingStoreStats *RollingStoreStats) StoreCreateOption {
+	return func(store *StoreInfo) {
+		store.rollingStoreStats = rollingStoreStats
+	}
+}
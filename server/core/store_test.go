@@ -0,0 +1,2050 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Suite(&testStoreSuite{})
+
+type testStoreSuite struct{}
+
+func (s *testStoreSuite) newStore(id uint64, opts ...StoreCreateOption) *StoreInfo {
+	meta := &metapb.Store{Id: id, State: metapb.StoreState_Up}
+	return NewStoreInfo(meta, opts...)
+}
+
+// storeIDs returns the IDs of the stores in order.
+func (s *testStoreSuite) storeIDs(stores []*StoreInfo) []uint64 {
+	var ids []uint64
+	for _, store := range stores {
+		ids = append(ids, store.GetID())
+	}
+	return ids
+}
+
+// newStoreStats returns store statistics of a 10 seconds heartbeat interval.
+func (s *testStoreSuite) newStoreStats(bytesWritten, bytesRead uint64) *pdpb.StoreStats {
+	return &pdpb.StoreStats{
+		BytesWritten: bytesWritten,
+		BytesRead:    bytesRead,
+		Interval:     &pdpb.TimeInterval{StartTimestamp: 0, EndTimestamp: 10},
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (s *testStoreSuite) TestStateChangeHandler(c *C) {
+	stores := NewStoresInfo()
+	type change struct {
+		storeID  uint64
+		old, new metapb.StoreState
+	}
+	var changes []change
+	stores.SetStateChangeHandler(func(storeID uint64, oldState, newState metapb.StoreState) {
+		// The map must already be updated when the handler is called.
+		c.Assert(stores.GetStore(storeID).GetState(), Equals, newState)
+		changes = append(changes, change{storeID, oldState, newState})
+	})
+
+	store := s.newStore(1)
+	stores.SetStore(store)
+	c.Assert(changes, HasLen, 0)
+
+	stores.SetStore(store.Clone(SetLeaderCount(10)))
+	c.Assert(changes, HasLen, 0)
+
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(changes, DeepEquals, []change{{1, metapb.StoreState_Up, metapb.StoreState_Offline}})
+	c.Assert(store.GetState(), Equals, metapb.StoreState_Up)
+
+	// The clone does not notify the handler of the origin.
+	cloned := stores.Clone()
+	cloned.SetStore(cloned.GetStore(1).Clone(SetStoreState(metapb.StoreState_Up)))
+	c.Assert(changes, HasLen, 1)
+
+	stores.SetStateChangeHandler(nil)
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Tombstone)))
+	c.Assert(changes, HasLen, 1)
+}
+
+func (s *testStoreSuite) TestUpdateStoreLabels(c *C) {
+	stores := NewStoresInfo()
+	labels := []*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "host", Value: "h1"}}
+	store := s.newStore(1, SetStoreLabels(labels))
+	stores.SetStore(store)
+
+	err := stores.UpdateStoreLabels(1, []*metapb.StoreLabel{{Key: "", Value: "v"}}, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Code(), Equals, StoreLabelInvalidCode)
+	err = stores.UpdateStoreLabels(1, []*metapb.StoreLabel{{Key: "zone", Value: ""}}, []string{"zone"})
+	c.Assert(err, NotNil)
+	c.Assert(err.Code(), Equals, StoreLabelInvalidCode)
+	c.Assert(stores.GetStore(1), Equals, store)
+
+	err = stores.UpdateStoreLabels(1, []*metapb.StoreLabel{{Key: "host", Value: "h2"}, {Key: "rack", Value: "r1"}}, []string{"zone"})
+	c.Assert(err, IsNil)
+	newStore := stores.GetStore(1)
+	c.Assert(newStore.GetLabelValue("zone"), Equals, "z1")
+	c.Assert(newStore.GetLabelValue("host"), Equals, "h2")
+	c.Assert(newStore.GetLabelValue("rack"), Equals, "r1")
+	// The original store is not modified.
+	c.Assert(store.GetLabelValue("host"), Equals, "h1")
+	c.Assert(store.GetLabels(), HasLen, 2)
+
+	c.Assert(stores.UpdateStoreLabels(2, nil, nil).Code(), Equals, errcode.NotFoundCode)
+}
+
+func (s *testStoreSuite) TestHotStores(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.GetHotWriteStores(2), HasLen, 0)
+
+	for id, written := range []uint64{1000, 1000, 1000, 1000, 10000} {
+		stores.SetStore(s.newStore(uint64(id+1), SetStoreStats(s.newStoreStats(written, written/10))))
+	}
+	// An offline store is not taken into account.
+	stores.SetStore(s.newStore(6, SetStoreState(metapb.StoreState_Offline), SetStoreStats(s.newStoreStats(100000, 100000))))
+
+	c.Assert(stores.GetHotWriteStores(2), DeepEquals, []uint64{5})
+	c.Assert(stores.GetHotReadStores(2), DeepEquals, []uint64{5})
+	c.Assert(stores.GetHotWriteStores(5), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestResetStoreStats(c *C) {
+	stores := NewStoresInfo()
+	store := s.newStore(1)
+	for i := 0; i < 3; i++ {
+		store = store.Clone(SetStoreStats(s.newStoreStats(1000, 1000)))
+		stores.SetStore(store)
+	}
+	c.Assert(store.GetRollingStoreStats().GetBytesWriteRate(), Equals, 100.0)
+	c.Assert(stores.TotalBytesWriteRate(), Equals, 100.0)
+
+	stores.ResetStoreStats(1)
+	c.Assert(store.GetRollingStoreStats().GetBytesWriteRate(), Equals, 0.0)
+	c.Assert(store.GetRollingStoreStats().GetBytesReadRate(), Equals, 0.0)
+	c.Assert(stores.TotalBytesWriteRate(), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestLatency(c *C) {
+	store := s.newStore(1)
+	c.Assert(store.GetApplyLatency(), Equals, time.Duration(0))
+	c.Assert(store.GetCommitLatency(), Equals, time.Duration(0))
+
+	store = store.Clone(SetApplyLatency(5*time.Millisecond), SetCommitLatency(3*time.Millisecond))
+	c.Assert(store.GetApplyLatency(), Equals, 5*time.Millisecond)
+	c.Assert(store.GetCommitLatency(), Equals, 3*time.Millisecond)
+
+	// Latency is kept across updates which do not report it.
+	store = store.Clone(SetLeaderCount(1))
+	c.Assert(store.GetApplyLatency(), Equals, 5*time.Millisecond)
+}
+
+func (s *testStoreSuite) TestCompareLocationLabel(c *C) {
+	labels := []string{"zone", "rack", "host"}
+	s1 := s.newStore(1, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "rack", Value: "r1"}}))
+	s2 := s.newStore(2, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "rack", Value: "r2"}}))
+	s3 := s.newStore(3, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}}))
+
+	key, ok := s1.CompareLocationLabel(s2, labels)
+	c.Assert(ok, IsTrue)
+	c.Assert(key, Equals, "rack")
+	key, ok = s1.CompareLocationLabel(s3, labels)
+	c.Assert(ok, IsFalse)
+	c.Assert(key, Equals, "")
+}
+
+func (s *testStoreSuite) TestStoreIsolationLevel(c *C) {
+	labels := []string{"zone", "host"}
+	newStore := func(id uint64, zone, host string) *StoreInfo {
+		return s.newStore(id, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}, {Key: "host", Value: host}}))
+	}
+	s1 := newStore(1, "z1", "h1")
+	s2 := newStore(2, "z1", "h2")
+	s3 := newStore(3, "z2", "h3")
+	s4 := newStore(4, "z2", "h3")
+
+	c.Assert(StoreIsolationLevel(nil, labels), Equals, 2)
+	c.Assert(StoreIsolationLevel([]*StoreInfo{s1}, labels), Equals, 2)
+	c.Assert(StoreIsolationLevel([]*StoreInfo{s1, s3}, labels), Equals, 0)
+	c.Assert(StoreIsolationLevel([]*StoreInfo{s1, s2, s3}, labels), Equals, 1)
+	c.Assert(StoreIsolationLevel([]*StoreInfo{s1, s3, s4}, labels), Equals, -1)
+}
+
+func (s *testStoreSuite) TestAutoTuneRegionWeights(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 200}), SetLeaderWeight(2)))
+	stores.SetStore(s.newStore(2, SetStoreStats(&pdpb.StoreStats{Capacity: 100})))
+	stores.SetStore(s.newStore(3, SetStoreStats(&pdpb.StoreStats{Capacity: 1000}), SetStoreState(metapb.StoreState_Offline)))
+
+	stores.AutoTuneRegionWeights()
+	c.Assert(stores.GetStore(1).GetRegionWeight(), Equals, 1.0)
+	c.Assert(stores.GetStore(1).GetLeaderWeight(), Equals, 2.0)
+	c.Assert(stores.GetStore(2).GetRegionWeight(), Equals, 0.5)
+	c.Assert(stores.GetStore(3).GetRegionWeight(), Equals, 1.0)
+}
+
+func (s *testStoreSuite) TestSetRegionWeightByLabel(c *C) {
+	stores := NewStoresInfo()
+	for id, zone := range map[uint64]string{1: "z1", 2: "z1", 3: "z2"} {
+		stores.SetStore(s.newStore(id, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}})))
+	}
+
+	count, err := stores.SetRegionWeightByLabel("zone", "z1", 0.5)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+	c.Assert(stores.GetStore(1).GetRegionWeight(), Equals, 0.5)
+	c.Assert(stores.GetStore(2).GetRegionWeight(), Equals, 0.5)
+	c.Assert(stores.GetStore(3).GetRegionWeight(), Equals, 1.0)
+	count, err = stores.SetRegionWeightByLabel("zone", "z3", 0.5)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+
+	for _, weight := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		count, err = stores.SetRegionWeightByLabel("zone", "z2", weight)
+		c.Assert(err, NotNil)
+		c.Assert(count, Equals, 0)
+	}
+	c.Assert(stores.GetStore(3).GetRegionWeight(), Equals, 1.0)
+	c.Assert(stores.StoresWithInvalidWeights(), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestDeepCloneStats(c *C) {
+	store := s.newStore(1)
+	store.GetRollingStoreStats().Observe(s.newStoreStats(1000, 0))
+
+	shared := store.Clone()
+	c.Assert(shared.GetRollingStoreStats(), Equals, store.GetRollingStoreStats())
+
+	cloned := store.Clone(DeepCloneStats())
+	c.Assert(cloned.GetRollingStoreStats().GetBytesWriteRate(), Equals, 100.0)
+	for i := 0; i < 3; i++ {
+		cloned.GetRollingStoreStats().Observe(s.newStoreStats(5000, 0))
+	}
+	c.Assert(cloned.GetRollingStoreStats().GetBytesWriteRate(), Equals, 500.0)
+	c.Assert(store.GetRollingStoreStats().GetBytesWriteRate(), Equals, 100.0)
+}
+
+func (s *testStoreSuite) TestSortStoresBy(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetLeaderSize(30)))
+	stores.SetStore(s.newStore(2, SetLeaderSize(10)))
+	stores.SetStore(s.newStore(3, SetLeaderSize(10)))
+
+	for i := 0; i < 10; i++ {
+		c.Assert(s.storeIDs(stores.SortStoresBy(ByLeaderScore())), DeepEquals, []uint64{2, 3, 1})
+	}
+}
+
+func (s *testStoreSuite) TestSimpleRegionScore(c *C) {
+	stores := NewStoresInfo()
+	newStore := func(id uint64, regionSize int64, available uint64) *StoreInfo {
+		stats := &pdpb.StoreStats{Capacity: 100 << 30, Available: available << 30}
+		return s.newStore(id, SetStoreStats(stats), SetRegionSize(regionSize<<10))
+	}
+	stores.SetStore(newStore(1, 20, 80))
+	stores.SetStore(newStore(2, 10, 90))
+	stores.SetStore(newStore(3, 15, 10))
+	c.Assert(stores.GetStore(2).SimpleRegionScore(), Equals, 10240.0/92160)
+
+	c.Assert(s.storeIDs(stores.SortStoresBy(BySimpleRegionScore())), DeepEquals, []uint64{2, 1, 3})
+	c.Assert(s.storeIDs(stores.SortStoresBy(ByRegionScore(0.6, 0.8))), DeepEquals, []uint64{2, 1, 3})
+
+	// A low space store keeps the highest staged score regardless of weight,
+	// while the simple score scales with it.
+	stores.SetStore(stores.GetStore(3).Clone(SetRegionWeight(20)))
+	c.Assert(s.storeIDs(stores.SortStoresBy(BySimpleRegionScore())), DeepEquals, []uint64{3, 2, 1})
+	c.Assert(s.storeIDs(stores.SortStoresBy(ByRegionScore(0.6, 0.8))), DeepEquals, []uint64{2, 1, 3})
+}
+
+func (s *testStoreSuite) TestSanitizedAvailable(c *C) {
+	store := s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 40}))
+	c.Assert(store.SanitizedAvailable(), Equals, uint64(40))
+	c.Assert(store.AvailableRatio(), Equals, 0.4)
+
+	store = store.Clone(SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 150}))
+	c.Assert(store.GetAvailable(), Equals, uint64(150))
+	c.Assert(store.SanitizedAvailable(), Equals, uint64(100))
+	c.Assert(store.AvailableRatio(), Equals, 1.0)
+}
+
+func (s *testStoreSuite) TestStoresByAvailableRatio(c *C) {
+	stores := NewStoresInfo()
+	for id, available := range map[uint64]uint64{1: 30, 2: 80, 3: 50, 4: 0, 5: 90} {
+		stats := &pdpb.StoreStats{Capacity: 100, Available: available}
+		if id == 4 {
+			stats.Capacity = 0
+		}
+		stores.SetStore(s.newStore(id, SetStoreStats(stats)))
+	}
+	stores.SetStore(stores.GetStore(5).Clone(SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(s.storeIDs(stores.StoresByAvailableRatio(true)), DeepEquals, []uint64{2, 3, 1, 4})
+	c.Assert(s.storeIDs(stores.StoresByAvailableRatio(false)), DeepEquals, []uint64{1, 3, 2, 4})
+}
+
+func (s *testStoreSuite) TestSpaceStage(c *C) {
+	newStore := func(available uint64) *StoreInfo {
+		return s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 100 << 30, Available: available << 30}))
+	}
+	c.Assert(newStore(80).SpaceStage(0.6, 0.8), Equals, HighSpace)
+	c.Assert(newStore(40).SpaceStage(0.6, 0.8), Equals, HighSpace)
+	c.Assert(newStore(30).SpaceStage(0.6, 0.8), Equals, TransitionSpace)
+	c.Assert(newStore(19).SpaceStage(0.6, 0.8), Equals, LowSpace)
+	c.Assert(newStore(5).SpaceStage(0.6, 0.8), Equals, LowSpace)
+}
+
+func (s *testStoreSuite) TestExplainRegionScore(c *C) {
+	newStore := func(available uint64) *StoreInfo {
+		stats := &pdpb.StoreStats{Capacity: 100 << 30, Available: available << 30, UsedSize: (100 - available) << 30}
+		return s.newStore(1, SetStoreStats(stats), SetRegionSize(int64(100-available)<<10), SetRegionWeight(2))
+	}
+	for available, stage := range map[uint64]SpaceStage{80: HighSpace, 30: TransitionSpace, 5: LowSpace} {
+		store := newStore(available)
+		e := store.ExplainRegionScore(0.6, 0.8, 0)
+		c.Assert(e.Stage, Equals, stage)
+		c.Assert(e.Stage, Equals, store.SpaceStage(0.6, 0.8))
+		c.Assert(e.Amplification, Equals, 1.0)
+		c.Assert(e.Weight, Equals, 2.0)
+		c.Assert(e.Score, Equals, store.RegionScore(0.6, 0.8, 0))
+		c.Assert(e.K != 0, Equals, stage == TransitionSpace)
+	}
+}
+
+func (s *testStoreSuite) TestFindDuplicateAddresses(c *C) {
+	stores := NewStoresInfo()
+	for id, address := range map[uint64]string{1: "a:20160", 2: "b:20160", 3: "a:20160", 4: "b:20160"} {
+		stores.SetStore(NewStoreInfo(&metapb.Store{Id: id, Address: address}))
+	}
+	stores.SetStore(stores.GetStore(4).Clone(SetStoreState(metapb.StoreState_Tombstone)))
+
+	c.Assert(stores.FindDuplicateAddresses(), DeepEquals, map[string][]uint64{"a:20160": {1, 3}})
+}
+
+func (s *testStoreSuite) TestCompareAndSetStore(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.CompareAndSetStore(s.newStore(1), s.newStore(1)), IsFalse)
+	c.Assert(stores.CompareAndSetStore(nil, s.newStore(1)), IsTrue)
+
+	// The writers build the new store without holding the lock, and retry if
+	// the store is changed meanwhile, while the reader scans all the stores.
+	const n = 100
+	var (
+		mu      sync.RWMutex
+		writers sync.WaitGroup
+		reader  sync.WaitGroup
+	)
+	done := make(chan struct{})
+	var overcounted bool
+	reader.Add(1)
+	go func() {
+		defer reader.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			mu.RLock()
+			for _, store := range stores.GetStores() {
+				overcounted = overcounted || store.GetRegionCount() > 2*n
+			}
+			stores.TotalBytesWriteRate()
+			mu.RUnlock()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for j := 0; j < n; j++ {
+				for {
+					mu.RLock()
+					old := stores.GetStore(1)
+					mu.RUnlock()
+					store := old.Clone(SetRegionCount(old.GetRegionCount() + 1))
+					mu.Lock()
+					ok := stores.CompareAndSetStore(old, store)
+					mu.Unlock()
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	close(done)
+	reader.Wait()
+	c.Assert(overcounted, IsFalse)
+	c.Assert(stores.GetStore(1).GetRegionCount(), Equals, 2*n)
+}
+
+func (s *testStoreSuite) TestStoreEvents(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1))
+	c.Assert(stores.GetStore(1).GetRecentEvents(), HasLen, 0)
+
+	c.Assert(stores.BlockStore(1), IsNil)
+	stores.UnblockStore(1)
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.GetStore(1).GetRecentEvents(), DeepEquals, []StoreEvent{
+		{Time: clock.Now(), Kind: StoreEventBlock},
+		{Time: clock.Now(), Kind: StoreEventUnblock},
+		{Time: clock.Now(), Kind: StoreEventStateChange, Detail: "Up -> Offline"},
+	})
+
+	store := stores.GetStore(1)
+	for i := 0; i < storeEventHistorySize; i++ {
+		clock.advance(time.Second)
+		store.RecordEvent("test", fmt.Sprint(i))
+	}
+	events := store.Clone().GetRecentEvents()
+	c.Assert(events, HasLen, storeEventHistorySize)
+	c.Assert(events[0].Detail, Equals, "0")
+	c.Assert(events[storeEventHistorySize-1].Detail, Equals, fmt.Sprint(storeEventHistorySize-1))
+	c.Assert(events[storeEventHistorySize-1].Time, Equals, clock.Now())
+}
+
+func (s *testStoreSuite) TestBatchUpdateStoreStatus(c *C) {
+	newStores := func() *StoresInfo {
+		stores := NewStoresInfo()
+		for id := uint64(1); id <= 3; id++ {
+			stores.SetStore(s.newStore(id, SetStoreStats(s.newStoreStats(id*1000, id*100))))
+		}
+		return stores
+	}
+	updates := []StoreStatusUpdate{
+		{StoreID: 1, LeaderCount: 1, RegionCount: 2, PendingPeerCount: 3, LeaderSize: 4, RegionSize: 5},
+		{StoreID: 3, LeaderCount: 6, RegionCount: 7, PendingPeerCount: 8, LeaderSize: 9, RegionSize: 10},
+		{StoreID: 4, LeaderCount: 1},
+	}
+
+	sequential, batch := newStores(), newStores()
+	for _, u := range updates {
+		sequential.UpdateStoreStatusLocked(u.StoreID, u.LeaderCount, u.RegionCount, u.PendingPeerCount, u.LeaderSize, u.RegionSize)
+	}
+	batch.BatchUpdateStoreStatus(updates)
+
+	c.Assert(batch.GetStoreCount(), Equals, sequential.GetStoreCount())
+	for _, store := range sequential.GetStores() {
+		other := batch.GetStore(store.GetID())
+		c.Assert(other.GetLeaderCount(), Equals, store.GetLeaderCount())
+		c.Assert(other.GetRegionCount(), Equals, store.GetRegionCount())
+		c.Assert(other.GetPendingPeerCount(), Equals, store.GetPendingPeerCount())
+		c.Assert(other.GetLeaderSize(), Equals, store.GetLeaderSize())
+		c.Assert(other.GetRegionSize(), Equals, store.GetRegionSize())
+	}
+	c.Assert(batch.TotalBytesWriteRate(), Equals, sequential.TotalBytesWriteRate())
+	c.Assert(batch.TotalBytesReadRate(), Equals, sequential.TotalBytesReadRate())
+}
+
+func (s *testStoreSuite) TestTimeToLowSpace(c *C) {
+	stats := s.newStoreStats(1000, 0)
+	stats.Capacity, stats.Available = 10000, 5000
+	store := s.newStore(1, SetStoreStats(stats))
+	c.Assert(store.TimeToLowSpace(0.8), Equals, NeverLowSpace)
+
+	store.GetRollingStoreStats().Observe(stats)
+	// (5000 - 2000) / 100 = 30 seconds.
+	c.Assert(store.TimeToLowSpace(0.8), Equals, 30*time.Second)
+
+	stats.Available = 1000
+	c.Assert(store.TimeToLowSpace(0.8), Equals, time.Duration(0))
+}
+
+func (s *testStoreSuite) TestHealthSummary(c *C) {
+	stores := NewStoresInfo()
+	now := time.Now()
+	stores.SetStore(s.newStore(1, SetLastHeartbeatTS(now), SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 50})))
+	stores.SetStore(s.newStore(2, SetLastHeartbeatTS(now), SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 10, IsBusy: true})))
+	stats := &pdpb.StoreStats{Capacity: 100, Available: 90}
+	stores.SetStore(s.newStore(3, SetLastHeartbeatTS(now.Add(-time.Minute)), SetStoreStats(stats), SetStoreState(metapb.StoreState_Offline)))
+	stores.SetStore(s.newStore(4, SetLastHeartbeatTS(now.Add(-time.Hour)), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(5, SetStoreState(metapb.StoreState_Tombstone)))
+
+	c.Assert(stores.HealthSummary(0.6, 0.8), Equals, ClusterHealth{
+		Total:        5,
+		Up:           3,
+		Offline:      1,
+		Tombstone:    1,
+		Disconnected: 2,
+		Unhealthy:    1,
+		LowSpace:     1,
+		Busy:         1,
+	})
+}
+
+func (s *testStoreSuite) TestOutlierRejection(c *C) {
+	stats := newRollingStoreStats()
+	stats.Observe(s.newStoreStats(1000, 1000))
+	stats.Observe(s.newStoreStats(1000000, 1000))
+	c.Assert(stats.GetBytesWriteRate(), Equals, 50050.0)
+
+	stats = newRollingStoreStats()
+	stats.SetOutlierFactor(10)
+	stats.Observe(s.newStoreStats(1000, 1000))
+	stats.Observe(s.newStoreStats(1000000, 1000))
+	c.Assert(stats.GetBytesWriteRate(), Equals, 100.0)
+	stats.Observe(s.newStoreStats(2000, 1000))
+	c.Assert(stats.GetBytesWriteRate(), Equals, 150.0)
+	c.Assert(stats.GetBytesReadRate(), Equals, 100.0)
+}
+
+func (s *testStoreSuite) TestEngine(c *C) {
+	stores := NewStoresInfo()
+	tikv := s.newStore(1)
+	tiflash := s.newStore(2, SetStoreLabels([]*metapb.StoreLabel{{Key: "engine", Value: "tiflash"}}))
+	stores.SetStore(tikv)
+	stores.SetStore(tiflash)
+
+	c.Assert(tikv.GetEngine(), Equals, "")
+	c.Assert(tikv.IsTiFlash(), IsFalse)
+	c.Assert(tiflash.GetEngine(), Equals, "tiflash")
+	c.Assert(tiflash.IsTiFlash(), IsTrue)
+	c.Assert(stores.GetTiKVStores(), DeepEquals, []*StoreInfo{tikv})
+	c.Assert(stores.GetTiFlashStores(), DeepEquals, []*StoreInfo{tiflash})
+}
+
+func (s *testStoreSuite) TestStoreEqual(c *C) {
+	store := s.newStore(1, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}}))
+	c.Assert(store.Equal(store.Clone()), IsTrue)
+	c.Assert(store.Equal(nil), IsFalse)
+
+	opts := []StoreCreateOption{
+		SetStoreState(metapb.StoreState_Offline),
+		SetStoreAddress("127.0.0.1:20160"),
+		SetStoreVersion("2.1.0"),
+		SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z2"}}),
+		SetStoreBlock(),
+		SetStoreFrozen(true),
+		SetLeaderCount(1),
+		SetRegionCount(1),
+		SetPendingPeerCount(1),
+		SetLeaderSize(1),
+		SetRegionSize(1),
+		SetLeaderWeight(2),
+		SetRegionWeight(2),
+		SetStoreStats(&pdpb.StoreStats{}),
+		DeepCloneStats(),
+	}
+	for _, opt := range opts {
+		c.Assert(store.Equal(store.Clone(opt)), IsFalse)
+	}
+	c.Assert(store.Equal(s.newStore(2)), IsFalse)
+}
+
+func (s *testStoreSuite) TestPeerLimit(c *C) {
+	store := s.newStore(1)
+	c.Assert(store.IsLimitExceeded(AddPeerKind, 1000), IsFalse)
+	c.Assert(store.IsLimitExceeded(RemovePeerKind, 1000), IsFalse)
+
+	store = store.Clone(SetAddPeerLimit(2), SetRemovePeerLimit(1))
+	c.Assert(store.IsLimitExceeded(AddPeerKind, 1), IsFalse)
+	c.Assert(store.IsLimitExceeded(AddPeerKind, 2), IsTrue)
+	c.Assert(store.IsLimitExceeded(RemovePeerKind, 0), IsFalse)
+	c.Assert(store.IsLimitExceeded(RemovePeerKind, 1), IsTrue)
+}
+
+func (s *testStoreSuite) TestLocationDistance(c *C) {
+	labels := []string{"zone", "host"}
+	newStore := func(id uint64, zone, host string) *StoreInfo {
+		return s.newStore(id, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}, {Key: "host", Value: host}}))
+	}
+	s1 := newStore(1, "z1", "h1")
+	s2 := newStore(2, "z1", "h2")
+	s3 := newStore(3, "z2", "h1")
+
+	weights := []float64{10, 1}
+	c.Assert(s1.LocationDistance(s1, labels, weights), Equals, 0.0)
+	c.Assert(s1.LocationDistance(s2, labels, weights), Equals, 1.0)
+	c.Assert(s1.LocationDistance(s3, labels, weights), Equals, 10.0)
+	c.Assert(s2.LocationDistance(s3, labels, weights), Equals, 11.0)
+	c.Assert(s2.LocationDistance(s3, labels, []float64{10}), Equals, 2.0)
+}
+
+func (s *testStoreSuite) TestStoresAffinity(c *C) {
+	newStore := func(id uint64, zone string) *StoreInfo {
+		return s.newStore(id, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}}))
+	}
+	isolated := []*StoreInfo{newStore(1, "z1"), newStore(2, "z2"), newStore(3, "z3")}
+	c.Assert(StoresViolateAntiAffinity(isolated, "zone"), IsFalse)
+	c.Assert(StoresSatisfyAffinity(isolated, "zone"), IsFalse)
+
+	colocated := []*StoreInfo{newStore(1, "z1"), newStore(2, "Z1")}
+	c.Assert(StoresViolateAntiAffinity(colocated, "zone"), IsTrue)
+	c.Assert(StoresSatisfyAffinity(colocated, "zone"), IsTrue)
+
+	partial := append(isolated, newStore(4, "z1"))
+	c.Assert(StoresViolateAntiAffinity(partial, "zone"), IsTrue)
+	c.Assert(StoresSatisfyAffinity(partial, "zone"), IsFalse)
+
+	// A store without the label may be at the same location with any store.
+	c.Assert(StoresViolateAntiAffinity([]*StoreInfo{newStore(1, "z1"), s.newStore(2)}, "zone"), IsTrue)
+	c.Assert(StoresViolateAntiAffinity(isolated[:1], "zone"), IsFalse)
+	c.Assert(StoresSatisfyAffinity(nil, "zone"), IsTrue)
+}
+
+func (s *testStoreSuite) TestForEach(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionCount(10)))
+	stores.SetStore(s.newStore(2, SetRegionCount(20)))
+	stores.SetStore(s.newStore(3, SetRegionCount(30), SetStoreState(metapb.StoreState_Offline)))
+
+	var expected, total, up int
+	for _, store := range stores.GetStores() {
+		expected += store.GetRegionCount()
+	}
+	stores.ForEach(func(store *StoreInfo) { total += store.GetRegionCount() })
+	stores.ForEachUp(func(store *StoreInfo) { up += store.GetRegionCount() })
+	c.Assert(total, Equals, expected)
+	c.Assert(up, Equals, 30)
+}
+
+func (s *testStoreSuite) TestSpaceRatios(c *C) {
+	stores := NewStoresInfo()
+	high, low := stores.GetSpaceRatios()
+	c.Assert(high, Equals, 0.6)
+	c.Assert(low, Equals, 0.8)
+
+	c.Assert(stores.SetSpaceRatios(0, 0.8), NotNil)
+	c.Assert(stores.SetSpaceRatios(0.6, 1), NotNil)
+	c.Assert(stores.SetSpaceRatios(0.8, 0.6), NotNil)
+	c.Assert(stores.SetSpaceRatios(0.7, 0.7), NotNil)
+	c.Assert(stores.SetSpaceRatios(0.5, 0.9), IsNil)
+
+	store := s.newStore(1, SetRegionSize(100), SetStoreStats(&pdpb.StoreStats{Capacity: 1 << 30, Available: 100 << 20, UsedSize: 900 << 20}))
+	stores.SetStore(store)
+	c.Assert(stores.RegionScoreOf(1, 10), Equals, store.RegionScore(0.5, 0.9, 10))
+	c.Assert(stores.RegionScoreOf(2, 10), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestStoreScorer(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionSize(100), SetLeaderCount(3)))
+	stores.SetStore(s.newStore(2, SetRegionSize(200), SetLeaderCount(1)))
+	c.Assert(stores.ScoreAll(), DeepEquals, map[uint64]float64{1: 100, 2: 200})
+
+	stores.SetScorer(StoreScorerFunc(func(store *StoreInfo) float64 {
+		return float64(store.GetLeaderCount())
+	}))
+	c.Assert(stores.ScoreAll(), DeepEquals, map[uint64]float64{1: 3, 2: 1})
+	c.Assert(stores.ScoreStore(2), Equals, 1.0)
+	c.Assert(stores.ScoreStore(3), Equals, 0.0)
+	c.Assert(stores.Clone().ScoreStore(1), Equals, 3.0)
+
+	stores.SetScorer(nil)
+	c.Assert(stores.ScoreStore(1), Equals, 100.0)
+}
+
+func (s *testStoreSuite) TestHasClockSkew(c *C) {
+	now := time.Now()
+	newStore := func(start time.Time) *StoreInfo {
+		return s.newStore(1, SetLastHeartbeatTS(now), SetStoreStats(&pdpb.StoreStats{StartTime: uint32(start.Unix())}))
+	}
+	c.Assert(newStore(now.Add(-time.Hour)).HasClockSkew(time.Minute), IsFalse)
+	c.Assert(newStore(now.Add(30*time.Second)).HasClockSkew(time.Minute), IsFalse)
+	c.Assert(newStore(now.Add(time.Hour)).HasClockSkew(time.Minute), IsTrue)
+}
+
+func (s *testStoreSuite) TestPenalizedRegionScore(c *C) {
+	stats := &pdpb.StoreStats{Capacity: 1 << 30, Available: 900 << 20, UsedSize: 100 << 20}
+	store := s.newStore(1, SetRegionSize(100), SetRegionWeight(2), SetStoreStats(stats))
+	c.Assert(store.PenalizedRegionScore(0.6, 0.8, 0, 10), Equals, store.RegionScore(0.6, 0.8, 0))
+
+	pending := store.Clone(SetPendingPeerCount(5))
+	c.Assert(pending.PenalizedRegionScore(0.6, 0.8, 0, 0), Equals, store.RegionScore(0.6, 0.8, 0))
+	c.Assert(pending.PenalizedRegionScore(0.6, 0.8, 0, 10), Equals, store.RegionScore(0.6, 0.8, 0)+25)
+}
+
+func (s *testStoreSuite) TestCapacityChange(c *C) {
+	store := s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 1000}))
+	delta, changed := store.CapacityChangedSinceLastHeartbeat()
+	c.Assert(changed, IsFalse)
+	c.Assert(delta, Equals, int64(0))
+
+	store = store.Clone(SetStoreStats(&pdpb.StoreStats{Capacity: 1000}))
+	_, changed = store.CapacityChangedSinceLastHeartbeat()
+	c.Assert(changed, IsFalse)
+
+	store = store.Clone(SetStoreStats(&pdpb.StoreStats{Capacity: 600}))
+	delta, changed = store.CapacityChangedSinceLastHeartbeat()
+	c.Assert(changed, IsTrue)
+	c.Assert(delta, Equals, int64(-400))
+
+	// Other updates keep the change until the next heartbeat.
+	store = store.Clone(SetLeaderCount(1))
+	delta, changed = store.CapacityChangedSinceLastHeartbeat()
+	c.Assert(changed, IsTrue)
+	c.Assert(delta, Equals, int64(-400))
+}
+
+func (s *testStoreSuite) TestNormalizedRegionScores(c *C) {
+	stores := NewStoresInfo()
+	stats := &pdpb.StoreStats{Capacity: 1 << 30, Available: 900 << 20, UsedSize: 100 << 20}
+	stores.SetStore(s.newStore(1, SetRegionSize(100), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(2, SetRegionSize(200), SetStoreStats(stats)))
+	c.Assert(stores.NormalizedRegionScores(0.6, 0.8), DeepEquals, map[uint64]float64{1: 0, 2: 1})
+
+	stores.SetStore(s.newStore(3, SetRegionSize(150), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(4, SetRegionSize(1000), SetStoreStats(stats), SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.NormalizedRegionScores(0.6, 0.8), DeepEquals, map[uint64]float64{1: 0, 2: 1, 3: 0.5})
+
+	stores = NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionSize(100), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(2, SetRegionSize(100), SetStoreStats(stats)))
+	c.Assert(stores.NormalizedRegionScores(0.6, 0.8), DeepEquals, map[uint64]float64{1: 0.5, 2: 0.5})
+}
+
+func (s *testStoreSuite) TestVersion(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.MinClusterVersion(), Equals, "")
+
+	stores.SetStore(s.newStore(1, SetStoreVersion("2.1.10")))
+	stores.SetStore(s.newStore(2, SetStoreVersion("2.1.9")))
+	stores.SetStore(s.newStore(3, SetStoreVersion("v2.1.10")))
+	stores.SetStore(s.newStore(4, SetStoreVersion("2.0.0"), SetStoreState(metapb.StoreState_Tombstone)))
+	c.Assert(stores.GetVersionDistribution(), DeepEquals, map[string][]uint64{
+		"2.1.10":  {1},
+		"2.1.9":   {2},
+		"v2.1.10": {3},
+		"2.0.0":   {4},
+	})
+	c.Assert(stores.MinClusterVersion(), Equals, "2.1.9")
+
+	stores.SetStore(s.newStore(5, SetStoreVersion("unknown")))
+	c.Assert(stores.MinClusterVersion(), Equals, "2.1.9")
+	stores.SetStore(s.newStore(6, SetStoreVersion("2.1")))
+	c.Assert(stores.MinClusterVersion(), Equals, "2.1")
+
+	c.Assert(versionLess("2.1.9", "2.1.10"), IsTrue)
+	c.Assert(versionLess("2.1.10", "2.1.9"), IsFalse)
+	c.Assert(versionLess("2.1.x", "2.1.9"), IsFalse)
+}
+
+func (s *testStoreSuite) TestStoresByVersion(c *C) {
+	stores := NewStoresInfo()
+	for id, version := range map[uint64]string{1: "2.1.10", 2: "2.1.9", 3: "2.1.10", 4: "v2.1.10"} {
+		stores.SetStore(s.newStore(id, SetStoreVersion(version)))
+	}
+	c.Assert(stores.CountStoresByVersion(), DeepEquals, map[string]int{"2.1.10": 2, "2.1.9": 1, "v2.1.10": 1})
+
+	ids := make(map[uint64]struct{})
+	for _, store := range stores.GetStoresByVersion("2.1.10") {
+		ids[store.GetID()] = struct{}{}
+	}
+	c.Assert(ids, DeepEquals, map[uint64]struct{}{1: {}, 3: {}})
+	c.Assert(stores.GetStoresByVersion("2.1"), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestPendingPeerRate(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1))
+	stores.SetStore(s.newStore(2))
+	for _, count := range []int{50, 60, 55} {
+		stores.UpdateStoreStatusLocked(1, 0, 0, count, 0, 0)
+	}
+	for _, count := range []int{0, 100, 0} {
+		stores.BatchUpdateStoreStatus([]StoreStatusUpdate{{StoreID: 2, PendingPeerCount: count}})
+	}
+	c.Assert(stores.GetStore(1).GetRollingStoreStats().GetPendingPeerRate(), Equals, 55.0)
+	c.Assert(stores.GetStore(2).GetRollingStoreStats().GetPendingPeerRate(), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestLogFields(c *C) {
+	store := s.newStore(1,
+		SetStoreAddress("127.0.0.1:20160"),
+		SetRegionCount(10),
+		SetLeaderCount(5),
+		SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 40}),
+	)
+	c.Assert(store.LogFields(), DeepEquals, log.Fields{
+		"store_id":        uint64(1),
+		"state":           "Up",
+		"address":         "127.0.0.1:20160",
+		"region_count":    10,
+		"leader_count":    5,
+		"available_ratio": 0.4,
+	})
+}
+
+func (s *testStoreSuite) TestStatsConsistent(c *C) {
+	ok, reason := s.newStore(1).StatsConsistent()
+	c.Assert(ok, IsTrue)
+	c.Assert(reason, Equals, "")
+	ok, _ = s.newStore(1, SetRegionCount(2), SetRegionSize(20), SetLeaderCount(1), SetLeaderSize(10)).StatsConsistent()
+	c.Assert(ok, IsTrue)
+
+	ok, reason = s.newStore(1, SetRegionCount(2)).StatsConsistent()
+	c.Assert(ok, IsFalse)
+	c.Assert(reason, Equals, "region count 2 disagrees with region size 0")
+	ok, reason = s.newStore(1, SetRegionCount(2), SetRegionSize(20), SetLeaderSize(10)).StatsConsistent()
+	c.Assert(ok, IsFalse)
+	c.Assert(reason, Equals, "leader count 0 disagrees with leader size 10")
+}
+
+func (s *testStoreSuite) TestFreezeStore(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionCount(10), SetStoreStats(s.newStoreStats(1000, 0))))
+	c.Assert(stores.FreezeStore(2), NotNil)
+	c.Assert(stores.FreezeStore(1), IsNil)
+	c.Assert(stores.GetStore(1).IsFrozen(), IsTrue)
+
+	stores.UpdateStoreStatusLocked(1, 5, 20, 1, 50, 200)
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreStats(s.newStoreStats(9000, 0)), SetStoreState(metapb.StoreState_Offline)))
+	stores.SetStore(s.newStore(1, SetStoreState(metapb.StoreState_Offline)))
+	store := stores.GetStore(1)
+	c.Assert(store.IsFrozen(), IsTrue)
+	c.Assert(store.IsOffline(), IsTrue)
+	c.Assert(store.GetRegionCount(), Equals, 10)
+	c.Assert(store.GetLeaderCount(), Equals, 0)
+	c.Assert(store.GetBytesWritten(), Equals, uint64(1000))
+	c.Assert(store.GetRollingStoreStats().GetBytesWriteRate(), Equals, 100.0)
+
+	c.Assert(stores.UnfreezeStore(1), IsNil)
+	stores.UpdateStoreStatusLocked(1, 5, 20, 1, 50, 200)
+	c.Assert(stores.GetStore(1).IsFrozen(), IsFalse)
+	c.Assert(stores.GetStore(1).GetRegionCount(), Equals, 20)
+}
+
+func (s *testStoreSuite) TestStoreStatsModes(c *C) {
+	stats := NewRollingStoreStats(StoreStatsModes{BytesWrite: MeanMode})
+	store := s.newStore(1, SetRollingStoreStats(stats))
+	for _, written := range []uint64{10, 20, 9000} {
+		store.GetRollingStoreStats().Observe(s.newStoreStats(written, written))
+		store.GetRollingStoreStats().ObservePendingPeerCount(int(written))
+	}
+	c.Assert(stats.GetBytesWriteRate(), Equals, 301.0)
+	c.Assert(stats.GetBytesReadRate(), Equals, 2.0)
+	c.Assert(stats.GetPendingPeerRate(), Equals, 20.0)
+}
+
+func (s *testStoreSuite) TestGroupSpaceBalance(c *C) {
+	stores := NewStoresInfo()
+	newStore := func(id uint64, zone string, available uint64) *StoreInfo {
+		return s.newStore(id,
+			SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}}),
+			SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: available}))
+	}
+	stores.SetStore(newStore(1, "z1", 50))
+	stores.SetStore(newStore(2, "z1", 50))
+	stores.SetStore(newStore(3, "z2", 20))
+	stores.SetStore(newStore(4, "z2", 60))
+	stores.SetStore(newStore(5, "z3", 10))
+	stores.SetStore(newStore(6, "z3", 90).Clone(SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(stores.GroupByLabel("zone"), HasLen, 3)
+	c.Assert(stores.GroupByLabel("host")[""], HasLen, 6)
+	balance := stores.GroupSpaceBalance("zone")
+	c.Assert(balance, HasLen, 3)
+	c.Assert(balance["z1"], Equals, 0.0)
+	c.Assert(math.Abs(balance["z2"]-0.5) < 1e-9, IsTrue)
+	c.Assert(balance["z3"], Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestRegionScoreGuard(c *C) {
+	// The amplification is infinite if nothing is used, which makes the
+	// transition stage produce NaN.
+	stats := &pdpb.StoreStats{Capacity: 100 << 20, Available: 30 << 20}
+	store := s.newStore(1, SetRegionSize(100), SetStoreStats(stats))
+	c.Assert(store.SpaceStage(0.6, 0.8), Equals, TransitionSpace)
+	c.Assert(store.RegionScore(0.6, 0.8, 0), Equals, 100.0)
+	c.Assert(store.RegionScore(0.6, 0.8, 10), Equals, 110.0)
+
+	// The transition stage has no width if the space ratios are equal.
+	stats = &pdpb.StoreStats{Capacity: 100 << 20, Available: 30 << 20, UsedSize: 70 << 20}
+	store = s.newStore(2, SetRegionSize(100), SetStoreStats(stats))
+	for _, delta := range []int64{0, 10} {
+		score := store.RegionScore(0.7, 0.7, delta)
+		c.Assert(math.IsNaN(score) || math.IsInf(score, 0), IsFalse)
+	}
+	c.Assert(store.RegionScore(0.7, 0.7, 0) < store.RegionScore(0.7, 0.7, 10), IsTrue)
+}
+
+func (s *testStoreSuite) TestHotnessClass(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetStoreStats(s.newStoreStats(100, 100))))
+	stores.SetStore(s.newStore(2, SetStoreStats(s.newStoreStats(10000, 100))))
+	stores.SetStore(s.newStore(3, SetStoreStats(s.newStoreStats(100, 10000))))
+	stores.SetStore(s.newStore(4, SetStoreStats(s.newStoreStats(10000, 10000))))
+	stores.SetStore(s.newStore(5, SetStoreStats(s.newStoreStats(20000, 20000))))
+
+	c.Assert(stores.GetStore(1).HotnessClass(500, 500), Equals, ColdStore)
+	c.Assert(stores.GetStore(2).HotnessClass(500, 500), Equals, WriteHotStore)
+	c.Assert(stores.GetStore(3).HotnessClass(500, 500), Equals, ReadHotStore)
+	c.Assert(stores.GetStore(4).HotnessClass(500, 500), Equals, MixedHotStore)
+	c.Assert(stores.CountByHotness(500, 500), DeepEquals, map[HotnessClass]int{
+		ColdStore:     1,
+		WriteHotStore: 1,
+		ReadHotStore:  1,
+		MixedHotStore: 2,
+	})
+}
+
+func (s *testStoreSuite) TestHeartbeatInterval(c *C) {
+	start := time.Now()
+	store := s.newStore(1, SetLastHeartbeatTS(start))
+	c.Assert(store.AverageHeartbeatInterval(), Equals, time.Duration(0))
+	c.Assert(store.MaxHeartbeatInterval(), Equals, time.Duration(0))
+
+	var stores []*StoreInfo
+	for _, d := range []time.Duration{10, 20, 30, 90, 100, 110, 120, 130, 140} {
+		store = store.Clone(SetLastHeartbeatTS(start.Add(d * time.Second)))
+		stores = append(stores, store)
+	}
+	// Intervals are 10, 10, 10, 60, 10, 10, 10, 10, 10 seconds.
+	c.Assert(stores[3].MaxHeartbeatInterval(), Equals, 60*time.Second)
+	c.Assert(stores[3].AverageHeartbeatInterval(), Equals, 22500*time.Millisecond)
+	// Only the last 5 intervals are kept.
+	c.Assert(stores[7].MaxHeartbeatInterval(), Equals, 60*time.Second)
+	c.Assert(stores[8].MaxHeartbeatInterval(), Equals, 10*time.Second)
+	c.Assert(stores[8].AverageHeartbeatInterval(), Equals, 10*time.Second)
+}
+
+func (s *testStoreSuite) TestStoresInfoClone(c *C) {
+	stores := NewStoresInfo()
+	for id := uint64(1); id <= 3; id++ {
+		stores.SetStore(s.newStore(id, SetRegionCount(10), SetStoreStats(s.newStoreStats(1000, 1000))))
+	}
+	cloned := stores.Clone()
+	c.Assert(cloned.GetStoreCount(), Equals, 3)
+	c.Assert(cloned.TotalBytesWriteRate(), Equals, stores.TotalBytesWriteRate())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			store := stores.GetStore(1)
+			stores.SetStore(store.Clone(SetRegionCount(i), SetStoreStats(s.newStoreStats(uint64(i), 0))))
+			stores.SetStore(s.newStore(uint64(i + 10)))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		var total int
+		for _, store := range cloned.GetStores() {
+			total += store.GetRegionCount()
+			store.GetRollingStoreStats().GetBytesWriteRate()
+		}
+		c.Assert(total, Equals, 30)
+	}
+	wg.Wait()
+	c.Assert(cloned.GetStoreCount(), Equals, 3)
+	c.Assert(cloned.GetStore(1).GetRegionCount(), Equals, 10)
+}
+
+func (s *testStoreSuite) TestZoneQuota(c *C) {
+	stores := NewStoresInfo()
+	newStore := func(id uint64, zone string, size int64) *StoreInfo {
+		return s.newStore(id, SetRegionSize(size), SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}}))
+	}
+	stores.SetStore(newStore(1, "z1", 100))
+	stores.SetStore(newStore(2, "Z1", 50))
+	stores.SetStore(newStore(3, "z2", 500))
+
+	c.Assert(stores.ZoneRegionSize("zone", "z1"), Equals, int64(150))
+	c.Assert(stores.ZoneRegionSize("zone", "z3"), Equals, int64(0))
+	c.Assert(stores.IsZoneOverQuota("zone", "z1", 150), IsFalse)
+	c.Assert(stores.IsZoneOverQuota("zone", "z1", 149), IsTrue)
+}
+
+func (s *testStoreSuite) TestSuggestedBackoff(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1))
+	c.Assert(stores.GetStore(1).SuggestedBackoff(time.Second), Equals, time.Second)
+
+	for i := 1; i <= 3; i++ {
+		c.Assert(stores.BlockStore(1), IsNil)
+		stores.UnblockStore(1)
+		store := stores.GetStore(1)
+		c.Assert(store.GetBlockCount(), Equals, i)
+		c.Assert(store.SuggestedBackoff(time.Second), Equals, time.Second<<uint(i))
+	}
+	c.Assert(stores.GetStore(1).SuggestedBackoff(time.Hour), Equals, maxBlockBackoff)
+
+	// The block count decays over time.
+	store := stores.GetStore(1)
+	clock.advance(2*blockCountDecayInterval + time.Minute)
+	c.Assert(store.GetBlockCount(), Equals, 1)
+	clock.advance(time.Hour)
+	c.Assert(store.GetBlockCount(), Equals, 0)
+}
+
+func (s *testStoreSuite) TestReadOnly(c *C) {
+	store := s.newStore(1)
+	c.Assert(store.IsReadOnly(), IsFalse)
+	c.Assert(store.AllowAddPeer(), IsTrue)
+
+	store = store.Clone(SetReadOnly(true))
+	c.Assert(store.IsReadOnly(), IsTrue)
+	c.Assert(store.AllowAddPeer(), IsFalse)
+	c.Assert(store.IsBlocked(), IsFalse)
+	c.Assert(store.Clone().IsReadOnly(), IsTrue)
+
+	store = store.Clone(SetReadOnly(false))
+	c.Assert(store.AllowAddPeer(), IsTrue)
+	c.Assert(store.Clone(SetStoreState(metapb.StoreState_Offline)).AllowAddPeer(), IsFalse)
+}
+
+func (s *testStoreSuite) TestReservedRegionSize(c *C) {
+	store := s.newStore(1, SetRegionSize(100))
+	c.Assert(store.GetReservedRegionSize(), Equals, int64(0))
+
+	store.ReserveRegionSize(30)
+	store.ReserveRegionSize(20)
+	clone := store.Clone()
+	c.Assert(clone.GetReservedRegionSize(), Equals, int64(50))
+	c.Assert(store.ReservedRegionScore(0.6, 0.8, 10), Equals, store.RegionScore(0.6, 0.8, 60))
+
+	clone.ReleaseRegionSize(20)
+	store.ReleaseRegionSize(30)
+	c.Assert(store.GetReservedRegionSize(), Equals, int64(0))
+	c.Assert(store.ReservedRegionScore(0.6, 0.8, 10), Equals, store.RegionScore(0.6, 0.8, 10))
+
+	// The reservation never goes negative.
+	store.ReserveRegionSize(10)
+	store.ReleaseRegionSize(20)
+	c.Assert(store.GetReservedRegionSize(), Equals, int64(0))
+	store.ReleaseRegionSize(10)
+	c.Assert(store.GetReservedRegionSize(), Equals, int64(0))
+}
+
+func (s *testStoreSuite) TestHasInvalidCounts(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetLeaderCount(5), SetRegionCount(10)))
+	stores.SetStore(s.newStore(2, SetLeaderCount(11), SetRegionCount(10)))
+	stores.SetStore(s.newStore(3, SetRegionCount(-1)))
+	stores.SetStore(s.newStore(4))
+
+	c.Assert(stores.GetStore(1).HasInvalidCounts(), IsFalse)
+	c.Assert(stores.GetStore(2).HasInvalidCounts(), IsTrue)
+	c.Assert(stores.GetStore(3).HasInvalidCounts(), IsTrue)
+	c.Assert(stores.GetStore(4).HasInvalidCounts(), IsFalse)
+	c.Assert(stores.ValidateAllStores(), DeepEquals, []uint64{2, 3})
+}
+
+func (s *testStoreSuite) TestRegionScoreCached(c *C) {
+	var computed int
+	regionScoreComputeHook = func() { computed++ }
+	defer func() { regionScoreComputeHook = nil }()
+
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionSize(100)))
+	store := stores.GetStore(1)
+	score := store.RegionScoreCached(0.6, 0.8)
+	c.Assert(score, Equals, store.RegionScore(0.6, 0.8, 0))
+	c.Assert(store.RegionScoreCached(0.6, 0.8), Equals, score)
+	c.Assert(computed, Equals, 1)
+
+	// Different space ratios are recomputed.
+	store.RegionScoreCached(0.7, 0.9)
+	c.Assert(computed, Equals, 2)
+
+	// The replaced store has a fresh cache.
+	stores.SetRegionSize(1, 200)
+	store = stores.GetStore(1)
+	c.Assert(store.RegionScoreCached(0.7, 0.9), Equals, store.RegionScore(0.7, 0.9, 0))
+	c.Assert(computed, Equals, 3)
+
+	// Changing the max score invalidates the cache.
+	defer SetMaxScore(defaultMaxScore)
+	const gb = 1 << 30
+	store = s.newStore(2, SetRegionSize(900*1024), SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1024 * gb,
+		Available: 100 * gb,
+		UsedSize:  900 * gb,
+	}))
+	score = store.RegionScoreCached(0.6, 0.8)
+	c.Assert(SetMaxScore(2*defaultMaxScore), IsNil)
+	c.Assert(store.RegionScoreCached(0.6, 0.8), Not(Equals), score)
+	c.Assert(store.RegionScoreCached(0.6, 0.8), Equals, store.RegionScore(0.6, 0.8, 0))
+	c.Assert(computed, Equals, 5)
+}
+
+func (s *testStoreSuite) TestGetStoreStatsHistory(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.GetStoreStatsHistory(1), IsNil)
+	stores.SetStore(s.newStore(1))
+	for _, written := range []uint64{10, 20, 30, 40} {
+		stores.SetStore(stores.GetStore(1).Clone(SetStoreStats(s.newStoreStats(written, written*2))))
+	}
+	history := stores.GetStoreStatsHistory(1)
+	c.Assert(history.BytesWriteRate, DeepEquals, []float64{2, 3, 4})
+	c.Assert(history.BytesReadRate, DeepEquals, []float64{4, 6, 8})
+	c.Assert(history.KeysWriteRate, DeepEquals, []float64{0, 0, 0})
+	c.Assert(history.KeysReadRate, HasLen, 3)
+}
+
+func (s *testStoreSuite) TestBalanceRatio(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetLeaderCount(10), SetRegionCount(30)))
+	c.Assert(stores.LeaderBalanceRatio(), Equals, 1.0)
+	c.Assert(stores.RegionBalanceRatio(), Equals, 1.0)
+
+	stores.SetStore(s.newStore(2, SetLeaderCount(10), SetRegionCount(30)))
+	stores.SetStore(s.newStore(3, SetLeaderCount(10), SetRegionCount(30)))
+	c.Assert(stores.LeaderBalanceRatio(), Equals, 1.0)
+	c.Assert(stores.RegionBalanceRatio(), Equals, 1.0)
+
+	stores.SetStore(s.newStore(4, SetLeaderCount(40), SetRegionCount(60)))
+	stores.SetStore(s.newStore(5, SetLeaderCount(0), SetRegionCount(0), SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.LeaderBalanceRatio(), Equals, 0.25)
+	c.Assert(stores.RegionBalanceRatio(), Equals, 0.5)
+}
+
+func (s *testStoreSuite) TestMoveCost(c *C) {
+	idle := s.newStore(1, SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1024 * (1 << 20),
+		Available: 1024 * (1 << 20),
+	}))
+	busy := s.newStore(2, SetStoreStats(&pdpb.StoreStats{
+		Capacity:          1024 * (1 << 20),
+		Available:         256 * (1 << 20),
+		SendingSnapCount:  1,
+		ApplyingSnapCount: 2,
+		IsBusy:            true,
+	}))
+	full := s.newStore(3, SetStoreStats(&pdpb.StoreStats{Capacity: 1024 * (1 << 20)}))
+
+	c.Assert(idle.MoveCost(64), Equals, 64.0/1024)
+	c.Assert(busy.MoveCost(64), Equals, 64.0*4*2/256)
+	c.Assert(full.MoveCost(64), Equals, 64.0)
+	c.Assert(idle.MoveCost(64) < idle.MoveCost(128), IsTrue)
+	c.Assert(idle.MoveCost(64) < busy.MoveCost(64), IsTrue)
+}
+
+func (s *testStoreSuite) TestLabelsFingerprint(c *C) {
+	labels := func(kvs ...string) []*metapb.StoreLabel {
+		var labels []*metapb.StoreLabel
+		for i := 0; i < len(kvs); i += 2 {
+			labels = append(labels, &metapb.StoreLabel{Key: kvs[i], Value: kvs[i+1]})
+		}
+		return labels
+	}
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetStoreLabels(labels("zone", "z1", "host", "h1"))))
+	stores.SetStore(s.newStore(2, SetStoreLabels(labels("host", "h1", "zone", "z1"))))
+	stores.SetStore(s.newStore(3, SetStoreLabels(labels("Zone", "Z1", "HOST", "h1"))))
+	stores.SetStore(s.newStore(4, SetStoreLabels(labels("zone", "z1", "host", "h2"))))
+
+	fingerprint := stores.GetStore(1).LabelsFingerprint()
+	c.Assert(stores.GetStore(2).LabelsFingerprint(), Equals, fingerprint)
+	c.Assert(stores.GetStore(3).LabelsFingerprint(), Equals, fingerprint)
+	c.Assert(stores.GetStore(4).LabelsFingerprint(), Not(Equals), fingerprint)
+	c.Assert(stores.StoresWithSameTopology(1), DeepEquals, []uint64{2, 3})
+	c.Assert(stores.StoresWithSameTopology(4), HasLen, 0)
+	c.Assert(stores.StoresWithSameTopology(5), IsNil)
+
+	// The fingerprint follows the label changes.
+	c.Assert(stores.UpdateStoreLabels(4, labels("host", "h1"), nil), IsNil)
+	c.Assert(stores.StoresWithSameTopology(1), DeepEquals, []uint64{2, 3, 4})
+	c.Assert(stores.GetStore(4).Clone(SetLeaderCount(1)).LabelsFingerprint(), Equals, fingerprint)
+	store := NewStoreInfo(&metapb.Store{Id: 5, Labels: labels("host", "h1", "zone", "z1")})
+	c.Assert(store.LabelsFingerprint(), Equals, fingerprint)
+}
+
+func (s *testStoreSuite) TestObserveInvalidInterval(c *C) {
+	stats := newRollingStoreStats()
+	stats.Observe(&pdpb.StoreStats{
+		BytesWritten: 100,
+		BytesRead:    100,
+		KeysWritten:  100,
+		KeysRead:     100,
+		Interval:     &pdpb.TimeInterval{StartTimestamp: 20, EndTimestamp: 10},
+	})
+	stats.Observe(&pdpb.StoreStats{
+		BytesWritten: 100,
+		Interval:     &pdpb.TimeInterval{StartTimestamp: 10, EndTimestamp: 10},
+	})
+	snapshot := stats.Snapshot()
+	c.Assert(snapshot.BytesWriteRate, HasLen, 0)
+	c.Assert(snapshot.BytesReadRate, HasLen, 0)
+	c.Assert(snapshot.KeysWriteRate, HasLen, 0)
+	c.Assert(snapshot.KeysReadRate, HasLen, 0)
+
+	stats.Observe(s.newStoreStats(100, 100))
+	for _, rate := range stats.Snapshot().BytesWriteRate {
+		c.Assert(rate >= 0, IsTrue)
+	}
+	c.Assert(stats.GetBytesWriteRate(), Equals, 10.0)
+}
+
+func (s *testStoreSuite) TestDecommissionProgress(c *C) {
+	store := s.newStore(1, SetRegionCount(10))
+	c.Assert(store.DecommissionProgress(10), Equals, 0.0)
+	c.Assert(store.DecommissionProgress(20), Equals, 0.5)
+	c.Assert(store.DecommissionProgress(5), Equals, 0.0)
+	c.Assert(store.Clone(SetRegionCount(0)).DecommissionProgress(10), Equals, 1.0)
+
+	stores := NewStoresInfo()
+	stores.SetStore(store)
+	_, ok := stores.GetDecommissionProgress(1)
+	c.Assert(ok, IsFalse)
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Offline)))
+	progress, ok := stores.GetDecommissionProgress(1)
+	c.Assert(ok, IsTrue)
+	c.Assert(progress, Equals, 0.0)
+	stores.SetRegionCount(1, 5)
+	progress, _ = stores.GetDecommissionProgress(1)
+	c.Assert(progress, Equals, 0.5)
+	stores.SetRegionCount(1, 0)
+	progress, _ = stores.GetDecommissionProgress(1)
+	c.Assert(progress, Equals, 1.0)
+
+	// The initial count is cleared once the store is up again.
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Up)))
+	_, ok = stores.GetDecommissionProgress(1)
+	c.Assert(ok, IsFalse)
+	c.Assert(stores.offlineRegionCounts, HasLen, 0)
+}
+
+func (s *testStoreSuite) TestStatsAreStale(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetLastHeartbeatTS(time.Now())))
+	c.Assert(stores.GetStore(1).StatsAreStale(time.Minute), IsTrue)
+
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreStats(s.newStoreStats(100, 100))))
+	c.Assert(stores.GetStore(1).StatsAreStale(time.Minute), IsFalse)
+
+	// Heartbeats keep arriving with zero intervals.
+	stats := stores.GetStore(1).GetRollingStoreStats()
+	stats.lastUpdate = time.Now().Add(-2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		stores.SetStore(stores.GetStore(1).Clone(
+			SetStoreStats(&pdpb.StoreStats{Interval: &pdpb.TimeInterval{StartTimestamp: 10, EndTimestamp: 10}}),
+			SetLastHeartbeatTS(time.Now()),
+		))
+	}
+	store := stores.GetStore(1)
+	c.Assert(store.StatsAreStale(time.Minute), IsTrue)
+	c.Assert(store.IsDisconnected(), IsFalse)
+}
+
+func (s *testStoreSuite) TestMatchesLabels(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetStoreLabels([]*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "disk", Value: "ssd"},
+	})))
+	stores.SetStore(s.newStore(2, SetStoreLabels([]*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "disk", Value: "hdd"},
+	})))
+	stores.SetStore(s.newStore(3))
+
+	store := stores.GetStore(1)
+	c.Assert(store.MatchesLabels(map[string]string{"zone": "z1", "disk": "ssd"}), IsTrue)
+	c.Assert(store.MatchesLabels(map[string]string{"Zone": "Z1", "DISK": "SSD"}), IsTrue)
+	c.Assert(store.MatchesLabels(map[string]string{"zone": "z1", "disk": "hdd"}), IsFalse)
+	c.Assert(store.MatchesLabels(map[string]string{"zone": "z1", "host": "h1"}), IsFalse)
+	c.Assert(store.MatchesLabels(nil), IsTrue)
+
+	selectIDs := func(required map[string]string) map[uint64]struct{} {
+		ids := make(map[uint64]struct{})
+		for _, store := range stores.SelectStores(required) {
+			ids[store.GetID()] = struct{}{}
+		}
+		return ids
+	}
+	c.Assert(selectIDs(map[string]string{"zone": "z1", "disk": "ssd"}), DeepEquals, map[uint64]struct{}{1: {}})
+	c.Assert(selectIDs(map[string]string{"zone": "z1"}), DeepEquals, map[uint64]struct{}{1: {}, 2: {}})
+	c.Assert(selectIDs(nil), HasLen, 3)
+}
+
+func (s *testStoreSuite) TestRegionChurnRate(c *C) {
+	stats := newRollingStoreStats()
+	stats.Observe(s.newStoreStats(100, 100))
+	c.Assert(stats.GetSplitRate(), Equals, 0.0)
+	c.Assert(stats.GetMergeRate(), Equals, 0.0)
+
+	stats.ObserveRegionChurn(10, 0, 0)
+	c.Assert(stats.GetSplitRate(), Equals, 0.0)
+	for _, splits := range []uint64{10, 30, 20} {
+		stats.ObserveRegionChurn(splits, 5, 10)
+	}
+	c.Assert(stats.GetSplitRate(), Equals, 2.0)
+	c.Assert(stats.GetMergeRate(), Equals, 0.5)
+	c.Assert(stats.clone().GetSplitRate(), Equals, 2.0)
+	stats.ResetStats()
+	c.Assert(stats.GetSplitRate(), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestRegionChurnTS(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stores := NewStoresInfo()
+	for id := uint64(1); id <= 3; id++ {
+		stores.SetStore(s.newStore(id))
+	}
+	stats := stores.GetStore(1).GetRollingStoreStats()
+	c.Assert(stats.GetLastSplitTS().IsZero(), IsTrue)
+	c.Assert(stores.RecentlyActiveStores(time.Minute), HasLen, 0)
+
+	stats.ObserveRegionChurn(1, 0, 10)
+	splitTS := clock.Now()
+	clock.advance(time.Minute)
+	stats.ObserveRegionChurn(0, 2, 10)
+	c.Assert(stats.GetLastSplitTS(), Equals, splitTS)
+	c.Assert(stats.GetLastMergeTS(), Equals, clock.Now())
+	stores.GetStore(2).GetRollingStoreStats().ObserveRegionChurn(0, 0, 10)
+
+	clock.advance(time.Minute)
+	active := stores.RecentlyActiveStores(90 * time.Second)
+	c.Assert(active, HasLen, 1)
+	c.Assert(active[0].GetID(), Equals, uint64(1))
+	c.Assert(stores.RecentlyActiveStores(30*time.Second), HasLen, 0)
+
+	stats.ResetStats()
+	c.Assert(stats.GetLastMergeTS().IsZero(), IsTrue)
+}
+
+func (s *testStoreSuite) TestNetworkRate(c *C) {
+	stats := newRollingStoreStats()
+	stats.Observe(s.newStoreStats(100, 100))
+	c.Assert(stats.GetNetSendRate(), Equals, 0.0)
+	c.Assert(stats.GetNetRecvRate(), Equals, 0.0)
+
+	stats.ObserveNetworkBytes(100, 100, 0)
+	c.Assert(stats.GetNetSendRate(), Equals, 0.0)
+	for _, sent := range []uint64{1000, 3000, 2000} {
+		stats.ObserveNetworkBytes(sent, 500, 10)
+	}
+	c.Assert(stats.GetNetSendRate(), Equals, 200.0)
+	c.Assert(stats.GetNetRecvRate(), Equals, 50.0)
+	c.Assert(stats.clone().GetNetRecvRate(), Equals, 50.0)
+	stats.ResetStats()
+	c.Assert(stats.GetNetSendRate(), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestTargetRegionCount(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionCount(30), SetRegionWeight(1)))
+	stores.SetStore(s.newStore(2, SetRegionCount(30), SetRegionWeight(2)))
+	stores.SetStore(s.newStore(3, SetRegionCount(20), SetRegionWeight(5)))
+	stores.SetStore(s.newStore(4, SetRegionCount(100), SetStoreState(metapb.StoreState_Offline)))
+
+	var sum int
+	for id, target := range map[uint64]int{1: 10, 2: 20, 3: 50} {
+		c.Assert(stores.TargetRegionCount(id), Equals, target)
+		sum += stores.TargetRegionCount(id)
+	}
+	c.Assert(sum, Equals, 80)
+	c.Assert(stores.TargetRegionCount(4), Equals, 0)
+	c.Assert(stores.TargetRegionCount(5), Equals, 0)
+
+	c.Assert(stores.RegionCountDeviation(1), Equals, 20)
+	c.Assert(stores.RegionCountDeviation(3), Equals, -30)
+	c.Assert(stores.RegionCountDeviation(5), Equals, 0)
+}
+
+func (s *testStoreSuite) TestFlowStat(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.HottestReadStore(), IsNil)
+	for id, bytesRead := range map[uint64]uint64{1: 1000, 2: 3000, 3: 2000, 4: 5000} {
+		stats := s.newStoreStats(100, bytesRead)
+		stats.KeysWritten, stats.KeysRead = 10, bytesRead/10
+		stores.SetStore(s.newStore(id, SetStoreStats(stats)))
+	}
+	stores.SetStore(stores.GetStore(4).Clone(SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(stores.GetStore(2).ReadFlow(), Equals, FlowStat{Bytes: 300, Keys: 30})
+	c.Assert(stores.GetStore(2).WriteFlow(), Equals, FlowStat{Bytes: 10, Keys: 1})
+	c.Assert(stores.HottestReadStore().GetID(), Equals, uint64(2))
+}
+
+func (s *testStoreSuite) TestIneligibleReasons(c *C) {
+	stats := &pdpb.StoreStats{Capacity: 100 * (1 << 20), Available: 50 * (1 << 20)}
+	healthy := s.newStore(1, SetStoreStats(stats), SetLastHeartbeatTS(time.Now()))
+	c.Assert(healthy.IneligibleReasons(0.6, 0.8), HasLen, 0)
+
+	testCases := []struct {
+		opt    StoreCreateOption
+		reason string
+	}{
+		{SetStoreState(metapb.StoreState_Tombstone), "tombstone"},
+		{SetStoreState(metapb.StoreState_Offline), "offline"},
+		{SetLastHeartbeatTS(time.Now().Add(-time.Hour)), "disconnected"},
+		{SetStoreBlock(), "blocked"},
+		{SetReadOnly(true), "read-only"},
+		{SetStoreStats(&pdpb.StoreStats{Capacity: 100 * (1 << 20), Available: 50 * (1 << 20), IsBusy: true}), "busy"},
+		{SetStoreStats(&pdpb.StoreStats{Capacity: 100 * (1 << 20), Available: 10 * (1 << 20)}), "low space"},
+	}
+	for _, t := range testCases {
+		c.Assert(healthy.Clone(t.opt).IneligibleReasons(0.6, 0.8), DeepEquals, []string{t.reason})
+	}
+	c.Assert(healthy.Clone(SetStoreBlock(), SetReadOnly(true)).IneligibleReasons(0.6, 0.8), DeepEquals, []string{"blocked", "read-only"})
+}
+
+func (s *testStoreSuite) TestSetMaxScore(c *C) {
+	defer SetMaxScore(defaultMaxScore)
+	c.Assert(SetMaxScore(0), NotNil)
+	c.Assert(SetMaxScore(-1), NotNil)
+	c.Assert(SetMaxScore(math.Inf(1)), NotNil)
+	c.Assert(getMaxScore(), Equals, float64(defaultMaxScore))
+
+	const gb = 1 << 30
+	// A large store with plenty of space and a small one with low space.
+	large := s.newStore(1, SetRegionSize(2*defaultMaxScore), SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1 << 32 * gb,
+		Available: 1 << 31 * gb,
+		UsedSize:  1 << 31 * gb,
+	}))
+	low := s.newStore(2, SetRegionSize(900*1024), SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1024 * gb,
+		Available: 100 * gb,
+		UsedSize:  900 * gb,
+	}))
+	c.Assert(large.RegionScore(0.6, 0.8, 0) > low.RegionScore(0.6, 0.8, 0), IsTrue)
+
+	c.Assert(SetMaxScore(1<<40), IsNil)
+	c.Assert(large.RegionScore(0.6, 0.8, 0) < low.RegionScore(0.6, 0.8, 0), IsTrue)
+}
+
+func (s *testStoreSuite) TestSnapshotPressure(c *C) {
+	defer SetSnapshotCapacity(defaultSnapshotCapacity)
+	c.Assert(SetSnapshotCapacity(0), NotNil)
+	c.Assert(SetSnapshotCapacity(math.NaN()), NotNil)
+	c.Assert(SetSnapshotCapacity(10), IsNil)
+
+	stores := NewStoresInfo()
+	c.Assert(stores.MostSnapshotPressuredStore(), IsNil)
+	stores.SetStore(s.newStore(1))
+	stores.SetStore(s.newStore(2, SetStoreStats(&pdpb.StoreStats{SendingSnapCount: 1, ApplyingSnapCount: 1})))
+	stores.SetStore(s.newStore(3, SetStoreStats(&pdpb.StoreStats{SendingSnapCount: 8, ReceivingSnapCount: 4, ApplyingSnapCount: 3})))
+	stores.SetStore(s.newStore(4, SetStoreStats(&pdpb.StoreStats{SendingSnapCount: 20}), SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.GetStore(1).SnapshotPressure(), Equals, 0.0)
+	c.Assert(stores.GetStore(2).SnapshotPressure(), Equals, 0.2)
+	c.Assert(stores.GetStore(3).SnapshotPressure(), Equals, 1.5)
+	c.Assert(stores.MostSnapshotPressuredStore().GetID(), Equals, uint64(3))
+}
+
+func (s *testStoreSuite) TestAssertRegionScoreMonotonic(c *C) {
+	defer SetMaxScore(defaultMaxScore)
+	const gb = 1 << 30
+	store := s.newStore(1, SetRegionSize(900*1024), SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1024 * gb,
+		Available: 100 * gb,
+		UsedSize:  900 * gb,
+	}))
+	c.Assert(AssertRegionScoreMonotonic(store, 0.6, 0.8), IsNil)
+	c.Assert(AssertRegionScoreMonotonic(s.newStore(2), 0.6, 0.8), IsNil)
+
+	// A max score lower than the region size makes the transition decrease.
+	c.Assert(SetMaxScore(1024), IsNil)
+	err := AssertRegionScoreMonotonic(store, 0.6, 0.8)
+	c.Assert(err, NotNil)
+	c.Assert(err, ErrorMatches, ".*transition space stage")
+}
+
+func (s *testStoreSuite) TestSmoothAmplification(c *C) {
+	const gb = 1 << 30
+	// The used size drops right after compactions, and then recovers.
+	usedSizes := []uint64{500, 500, 420, 500, 500, 420, 500}
+	maxJump := func() float64 {
+		stores := NewStoresInfo()
+		stores.SetStore(s.newStore(1, SetRegionSize(500*1024)))
+		var jump, last float64
+		for i, used := range usedSizes {
+			stats := &pdpb.StoreStats{Capacity: 1024 * gb, Available: (800 - used) * gb, UsedSize: used * gb}
+			stores.SetStore(stores.GetStore(1).Clone(SetStoreStats(stats)))
+			score := stores.GetStore(1).RegionScore(0.6, 0.8, 0)
+			if i > 0 {
+				jump = math.Max(jump, math.Abs(score-last))
+			}
+			last = score
+		}
+		return jump
+	}
+	raw := maxJump()
+	SetSmoothAmplification(true)
+	defer SetSmoothAmplification(false)
+	smoothed := maxJump()
+	c.Assert(raw > 0, IsTrue)
+	c.Assert(smoothed < raw, IsTrue)
+}
+
+func (s *testStoreSuite) TestTotalFlow(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.TotalBytesWritten(), Equals, uint64(0))
+	for id := uint64(1); id <= 3; id++ {
+		stats := &pdpb.StoreStats{
+			BytesWritten: 100 * id,
+			BytesRead:    200 * id,
+			KeysWritten:  10 * id,
+			KeysRead:     20 * id,
+		}
+		stores.SetStore(s.newStore(id, SetStoreStats(stats)))
+	}
+	stores.SetStore(stores.GetStore(3).Clone(SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(stores.TotalBytesWritten(), Equals, uint64(300))
+	c.Assert(stores.TotalBytesRead(), Equals, uint64(600))
+	c.Assert(stores.TotalKeysWritten(), Equals, uint64(30))
+	c.Assert(stores.TotalKeysRead(), Equals, uint64(60))
+}
+
+func (s *testStoreSuite) TestSpaceByLabel(c *C) {
+	stores := NewStoresInfo()
+	newStore := func(id uint64, zone string, opts ...StoreCreateOption) *StoreInfo {
+		if zone != "" {
+			opts = append(opts, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: zone}}))
+		}
+		stats := &pdpb.StoreStats{Capacity: 100 * id, Available: 60 * id, UsedSize: 40 * id}
+		return s.newStore(id, append(opts, SetStoreStats(stats))...)
+	}
+	stores.SetStore(newStore(1, "z1"))
+	stores.SetStore(newStore(2, "z1"))
+	stores.SetStore(newStore(3, "z2"))
+	stores.SetStore(newStore(4, "z2", SetStoreState(metapb.StoreState_Offline)))
+	stores.SetStore(newStore(5, ""))
+
+	c.Assert(stores.SpaceByLabel("zone"), DeepEquals, map[string]SpaceStat{
+		"z1": {Capacity: 300, Available: 180, Used: 120},
+		"z2": {Capacity: 300, Available: 180, Used: 120},
+		"":   {Capacity: 500, Available: 300, Used: 200},
+	})
+}
+
+func (s *testStoreSuite) TestStoresExceedingAmplification(c *C) {
+	newStore := func(id uint64, regionSize int64, usedMB uint64, opts ...StoreCreateOption) *StoreInfo {
+		opts = append(opts, SetRegionSize(regionSize), SetStoreStats(&pdpb.StoreStats{UsedSize: usedMB * (1 << 20)}))
+		return s.newStore(id, opts...)
+	}
+	stores := NewStoresInfo()
+	stores.SetStore(newStore(1, 100, 100))
+	stores.SetStore(newStore(2, 500, 100))
+	stores.SetStore(newStore(3, 500, 0))
+	stores.SetStore(newStore(4, 500, 100, SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(stores.GetStore(1).CompressionAmplification(), Equals, 1.0)
+	c.Assert(stores.GetStore(2).CompressionAmplification(), Equals, 5.0)
+	c.Assert(stores.GetStore(3).CompressionAmplification(), Equals, 1.0)
+	c.Assert(stores.StoresExceedingAmplification(3), DeepEquals, []uint64{2})
+	c.Assert(stores.StoresExceedingAmplification(5), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestResourceAggregation(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.MeanResourceScore(LeaderKind, 0.6, 0.8), Equals, 0.0)
+	for id := uint64(1); id <= 3; id++ {
+		stores.SetStore(s.newStore(id,
+			SetLeaderCount(int(id)), SetLeaderSize(int64(10*id)),
+			SetRegionCount(int(2*id)), SetRegionSize(int64(20*id)),
+		))
+	}
+	stores.SetStore(stores.GetStore(3).Clone(SetStoreState(metapb.StoreState_Offline)))
+
+	c.Assert(stores.TotalResourceCount(LeaderKind), Equals, uint64(3))
+	c.Assert(stores.TotalResourceCount(RegionKind), Equals, uint64(6))
+	c.Assert(stores.TotalResourceSize(LeaderKind), Equals, int64(30))
+	c.Assert(stores.TotalResourceSize(RegionKind), Equals, int64(60))
+	c.Assert(stores.MeanResourceScore(LeaderKind, 0.6, 0.8), Equals, 15.0)
+
+	unknown := ResourceKind(100)
+	c.Assert(stores.TotalResourceCount(unknown), Equals, uint64(0))
+	c.Assert(stores.TotalResourceSize(unknown), Equals, int64(0))
+	c.Assert(stores.MeanResourceScore(unknown, 0.6, 0.8), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestResourceShare(c *C) {
+	stores := NewStoresInfo()
+	for id := uint64(1); id <= 3; id++ {
+		stores.SetStore(s.newStore(id, SetRegionCount(int(id))))
+	}
+	c.Assert(stores.LeaderShare(1), Equals, 0.0)
+
+	stores.SetLeaderCount(1, 7)
+	stores.SetLeaderCount(2, 1)
+	stores.SetLeaderCount(3, 5)
+	var leaderShares, regionShares float64
+	for id := uint64(1); id <= 3; id++ {
+		leaderShares += stores.LeaderShare(id)
+		regionShares += stores.RegionShare(id)
+	}
+	c.Assert(math.Abs(leaderShares-1) < 1e-9, IsTrue)
+	c.Assert(math.Abs(regionShares-1) < 1e-9, IsTrue)
+	c.Assert(stores.RegionShare(3), Equals, 0.5)
+	c.Assert(stores.RegionShare(4), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestStoresWithInvalidWeights(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1))
+	c.Assert(stores.StoresWithInvalidWeights(), HasLen, 0)
+
+	stores.SetStore(s.newStore(2, SetLeaderWeight(0)))
+	stores.SetStore(s.newStore(3, SetRegionWeight(-1)))
+	stores.SetStore(s.newStore(4, SetLeaderWeight(0.5), SetRegionWeight(2)))
+	c.Assert(stores.StoresWithInvalidWeights(), DeepEquals, []uint64{2, 3})
+}
+
+func (s *testStoreSuite) TestPauseScheduling(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stores := NewStoresInfo()
+	c.Assert(stores.PauseScheduling(1, time.Minute), NotNil)
+	stores.SetStore(s.newStore(1, SetLastHeartbeatTS(clock.Now())))
+	c.Assert(stores.GetStore(1).SchedulingPaused(), IsFalse)
+	c.Assert(stores.GetStore(1).canBeLeaderTarget(), IsTrue)
+
+	c.Assert(stores.PauseScheduling(1, time.Minute), IsNil)
+	store := stores.GetStore(1)
+	c.Assert(store.SchedulingPaused(), IsTrue)
+	c.Assert(store.AllowAddPeer(), IsFalse)
+	c.Assert(store.AllowLeaderTransferIn(), IsFalse)
+	c.Assert(store.canBeLeaderTarget(), IsFalse)
+	c.Assert(store.IsBlocked(), IsFalse)
+	c.Assert(store.Clone().SchedulingPaused(), IsTrue)
+
+	// Scheduling resumes after the deadline.
+	c.Assert(stores.PauseScheduling(1, 10*time.Second), IsNil)
+	clock.advance(11 * time.Second)
+	store = stores.GetStore(1)
+	c.Assert(store.SchedulingPaused(), IsFalse)
+	c.Assert(store.AllowAddPeer(), IsTrue)
+	c.Assert(store.AllowLeaderTransferIn(), IsTrue)
+	c.Assert(store.canBeLeaderTarget(), IsTrue)
+}
+
+func (s *testStoreSuite) TestGetStoresSortedByID(c *C) {
+	stores := NewStoresInfo()
+	for _, id := range []uint64{5, 3, 8, 1, 2} {
+		stores.SetStore(s.newStore(id))
+	}
+	c.Assert(s.storeIDs(stores.GetStoresSortedByID()), DeepEquals, []uint64{1, 2, 3, 5, 8})
+}
+
+func (s *testStoreSuite) TestHeadroom(c *C) {
+	newStore := func(id, available uint64, opts ...StoreCreateOption) *StoreInfo {
+		opts = append(opts, SetStoreStats(&pdpb.StoreStats{Capacity: 1000, Available: available}))
+		return s.newStore(id, opts...)
+	}
+	stores := NewStoresInfo()
+	c.Assert(stores.StoreWithMostHeadroom(), IsNil)
+	stores.SetStore(newStore(1, 300))
+	stores.SetStore(newStore(2, 500))
+	stores.SetStore(newStore(3, 500))
+	stores.SetStore(newStore(4, 900, SetStoreState(metapb.StoreState_Offline)))
+
+	store := stores.GetStore(2)
+	c.Assert(store.Headroom(), Equals, uint64(500))
+	c.Assert(store.HeadroomAfter(200), Equals, int64(300))
+	c.Assert(store.HeadroomAfter(600), Equals, int64(-100))
+	c.Assert(stores.StoreWithMostHeadroom().GetID(), Equals, uint64(2))
+}
+
+func (s *testStoreSuite) TestLabelDefaults(c *C) {
+	stores := NewStoresInfo()
+	stores.SetLabelDefaults([]*metapb.StoreLabel{
+		{Key: "zone", Value: "default-zone"},
+		{Key: "disk", Value: "ssd"},
+	})
+
+	stores.SetStore(s.newStore(1, SetStoreLabels([]*metapb.StoreLabel{{Key: "Zone", Value: "z1"}})))
+	store := stores.GetStore(1)
+	c.Assert(store.GetLabelValue("zone"), Equals, "z1")
+	c.Assert(store.GetLabelValue("disk"), Equals, "ssd")
+	c.Assert(store.GetLabels(), HasLen, 2)
+
+	stores.SetStore(s.newStore(2))
+	store = stores.GetStore(2)
+	c.Assert(store.GetLabelValue("zone"), Equals, "default-zone")
+	c.Assert(store.GetLabelValue("disk"), Equals, "ssd")
+
+	// The defaults are only applied when the store is first added.
+	stores.SetStore(s.newStore(2, SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z2"}})))
+	c.Assert(stores.GetStore(2).GetLabels(), HasLen, 1)
+}
+
+func (s *testStoreSuite) TestReadWriteRatio(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.MeanReadWriteRatio(), Equals, 0.0)
+	stores.SetStore(s.newStore(1, SetStoreStats(s.newStoreStats(0, 100))))
+	stores.SetStore(s.newStore(2, SetStoreStats(s.newStoreStats(100, 0))))
+	stores.SetStore(s.newStore(3, SetStoreStats(s.newStoreStats(100, 100))))
+	stores.SetStore(s.newStore(4, SetStoreStats(s.newStoreStats(100, 300))))
+	stores.SetStore(s.newStore(5))
+
+	c.Assert(stores.GetStore(1).ReadWriteRatio(), Equals, float64(maxReadWriteRatio))
+	c.Assert(stores.GetStore(2).ReadWriteRatio(), Equals, 0.0)
+	c.Assert(stores.GetStore(3).ReadWriteRatio(), Equals, 1.0)
+	c.Assert(stores.GetStore(5).ReadWriteRatio(), Equals, 0.0)
+
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.MeanReadWriteRatio(), Equals, 1.0)
+}
+
+func (s *testStoreSuite) TestOversizedStores(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionSize(1000)))
+	c.Assert(stores.OversizedStores(1.5), IsNil)
+
+	stores.SetStore(s.newStore(2, SetRegionSize(100)))
+	stores.SetStore(s.newStore(3, SetRegionSize(100)))
+	stores.SetStore(s.newStore(4, SetRegionSize(200)))
+	stores.SetStore(s.newStore(5, SetRegionSize(5000), SetStoreState(metapb.StoreState_Offline)))
+	// The mean region size of Up stores is 350.
+	c.Assert(stores.OversizedStores(1.5), DeepEquals, []uint64{1})
+	c.Assert(stores.OversizedStores(3), HasLen, 0)
+}
+
+func (s *testStoreSuite) TestExportMetrics(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionCount(10), SetLeaderCount(4), SetStoreStats(&pdpb.StoreStats{
+		Capacity:     100,
+		Available:    40,
+		BytesWritten: 100,
+		Interval:     &pdpb.TimeInterval{StartTimestamp: 0, EndTimestamp: 10},
+	})))
+	stores.SetStore(s.newStore(2, SetRegionCount(20)))
+
+	metrics := make(map[string]map[string]float64)
+	stores.ExportAllMetrics(func(name string, value float64, labels map[string]string) {
+		store := labels["store"]
+		if metrics[store] == nil {
+			metrics[store] = make(map[string]float64)
+		}
+		metrics[store][name] = value
+	})
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics["1"]["region_count"], Equals, 10.0)
+	c.Assert(metrics["1"]["leader_count"], Equals, 4.0)
+	c.Assert(metrics["1"]["store_available"], Equals, 40.0)
+	c.Assert(metrics["1"]["available_ratio"], Equals, 0.4)
+	c.Assert(metrics["1"]["bytes_write_rate"], Equals, 10.0)
+	c.Assert(metrics["2"]["region_count"], Equals, 20.0)
+	c.Assert(metrics["2"], HasLen, len(metrics["1"]))
+}
+
+func (s *testStoreSuite) TestSpaceAccountingValid(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 1000, Available: 400, UsedSize: 500})))
+	stores.SetStore(s.newStore(2, SetStoreStats(&pdpb.StoreStats{Capacity: 1000, Available: 505, UsedSize: 500})))
+	stores.SetStore(s.newStore(3, SetStoreStats(&pdpb.StoreStats{Capacity: 1000, Available: 600, UsedSize: 500})))
+
+	c.Assert(stores.GetStore(1).SpaceAccountingValid(), IsTrue)
+	c.Assert(stores.GetStore(2).SpaceAccountingValid(), IsTrue)
+	c.Assert(stores.GetStore(3).SpaceAccountingValid(), IsFalse)
+	c.Assert(stores.HealthSummary(0.6, 0.8).InvalidSpace, Equals, 1)
+}
+
+func (s *testStoreSuite) TestCheckSchedulable(c *C) {
+	stores := NewStoresInfo()
+	stats := &pdpb.StoreStats{Capacity: 100, Available: 50}
+	now := time.Now()
+	stores.SetStore(s.newStore(1, SetStoreStats(stats), SetLastHeartbeatTS(now)))
+	stores.SetStore(s.newStore(2, SetStoreStats(stats), SetLastHeartbeatTS(now), SetStoreState(metapb.StoreState_Tombstone)))
+	stores.SetStore(s.newStore(3, SetStoreStats(stats), SetLastHeartbeatTS(now), SetStoreBlock()))
+	stores.SetStore(s.newStore(4, SetStoreStats(stats), SetLastHeartbeatTS(now.Add(-time.Hour))))
+	stores.SetStore(s.newStore(5, SetStoreStats(&pdpb.StoreStats{Capacity: 100, Available: 10}), SetLastHeartbeatTS(now)))
+
+	c.Assert(stores.CheckSchedulable(1), IsNil)
+	testCases := []struct {
+		storeID uint64
+		code    errcode.Code
+	}{
+		{6, errcode.NotFoundCode},
+		{2, StoreTombstonedCode},
+		{3, StoreBlockedCode},
+		{4, StoreDisconnectedCode},
+		{5, StoreLowSpaceCode},
+	}
+	for _, t := range testCases {
+		err := stores.CheckSchedulable(t.storeID)
+		c.Assert(err, NotNil)
+		c.Assert(err.Code(), Equals, t.code)
+	}
+}
+
+func (s *testStoreSuite) TestRollingStoreStatsExpiry(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stats := newRollingStoreStats()
+	stats.SetExpiry(time.Minute)
+	stats.Observe(s.newStoreStats(100, 200))
+	c.Assert(stats.GetBytesWriteRate(), Equals, 10.0)
+	c.Assert(stats.GetBytesReadRate(), Equals, 20.0)
+
+	clock.advance(2 * time.Minute)
+	c.Assert(stats.GetBytesWriteRate(), Equals, 0.0)
+	c.Assert(stats.GetBytesReadRate(), Equals, 0.0)
+}
+
+func (s *testStoreSuite) TestBytesWriteRateConfident(c *C) {
+	stats := newRollingStoreStats()
+	c.Assert(stats.SampleCount(), Equals, 0)
+	stats.Observe(s.newStoreStats(100, 200))
+	rate, ok := stats.GetBytesWriteRateConfident(2)
+	c.Assert(rate, Equals, 10.0)
+	c.Assert(ok, IsFalse)
+
+	stats.Observe(s.newStoreStats(100, 200))
+	c.Assert(stats.SampleCount(), Equals, 2)
+	rate, ok = stats.GetBytesWriteRateConfident(2)
+	c.Assert(rate, Equals, 10.0)
+	c.Assert(ok, IsTrue)
+}
+
+func (s *testStoreSuite) TestBetterLeaderTargetThan(c *C) {
+	now := time.Now()
+	a := s.newStore(1, SetLeaderSize(100), SetLastHeartbeatTS(now))
+	b := s.newStore(2, SetLeaderSize(200), SetLastHeartbeatTS(now))
+	c.Assert(a.BetterLeaderTargetThan(b), IsTrue)
+	c.Assert(b.BetterLeaderTargetThan(a), IsFalse)
+
+	// Ties fall back to the lower store ID.
+	tie := b.Clone(SetLeaderSize(100))
+	c.Assert(a.BetterLeaderTargetThan(tie), IsTrue)
+	c.Assert(tie.BetterLeaderTargetThan(a), IsFalse)
+
+	for _, opt := range []StoreCreateOption{
+		SetStoreState(metapb.StoreState_Offline),
+		SetStoreStats(&pdpb.StoreStats{IsBusy: true}),
+		SetLastHeartbeatTS(now.Add(-time.Hour)),
+	} {
+		c.Assert(a.Clone(opt).BetterLeaderTargetThan(b), IsFalse)
+		c.Assert(b.BetterLeaderTargetThan(a.Clone(opt)), IsTrue)
+	}
+}
+
+func (s *testStoreSuite) TestEffectiveRegionWeight(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetRegionWeight(2), SetRegionSize(100)))
+	now := time.Now()
+	c.Assert(stores.GetStore(1).EffectiveRegionWeight(now), Equals, 2.0)
+
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Offline)))
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Up)))
+	store := stores.GetStore(1)
+	up := store.upTransitionTime
+	c.Assert(up.IsZero(), IsFalse)
+
+	c.Assert(store.EffectiveRegionWeight(up), Equals, 0.2)
+	c.Assert(store.EffectiveRegionWeight(up.Add(regionWeightWarmup/2)), Equals, 1.1)
+	c.Assert(store.EffectiveRegionWeight(up.Add(regionWeightWarmup)), Equals, 2.0)
+	c.Assert(store.EffectiveRegionWeight(up.Add(time.Hour)), Equals, 2.0)
+	c.Assert(store.WarmRegionScore(0.6, 0.8, 0, up), Equals, store.RegionScore(0.6, 0.8, 0)*10)
+	c.Assert(store.WarmRegionScore(0.6, 0.8, 0, up.Add(time.Hour)), Equals, store.RegionScore(0.6, 0.8, 0))
+
+	// Later updates keep the transition time.
+	stores.SetStore(store.Clone(SetRegionSize(200)))
+	c.Assert(stores.GetStore(1).upTransitionTime, Equals, up)
+}
+
+func (s *testStoreSuite) TestStoresStats(c *C) {
+	stores := NewStoresInfo()
+	stats := &pdpb.StoreStats{Capacity: 100, Available: 60, UsedSize: 40}
+	stores.SetStore(s.newStore(1, SetLeaderSize(10), SetRegionSize(30), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(2, SetLeaderSize(30), SetRegionSize(30), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(3, SetLeaderSize(50), SetStoreState(metapb.StoreState_Offline), SetStoreStats(stats)))
+	stores.SetStore(s.newStore(4, SetStoreState(metapb.StoreState_Tombstone)))
+
+	report := stores.Stats(0.6, 0.8)
+	c.Assert(report.LeaderScore, Equals, ScoreStats{Total: 40, Mean: 20, StdDev: 10})
+	c.Assert(report.RegionScore, Equals, ScoreStats{Total: 60, Mean: 30, StdDev: 0})
+	c.Assert(report.Capacity, Equals, uint64(200))
+	c.Assert(report.Available, Equals, uint64(120))
+	c.Assert(report.Used, Equals, uint64(80))
+	c.Assert(report.StateCounts, DeepEquals, map[string]int{"Up": 2, "Offline": 1, "Tombstone": 1})
+}
+
+func (s *testStoreSuite) TestDiskType(c *C) {
+	stores := NewStoresInfo()
+	for id, disk := range map[uint64]string{1: "ssd", 2: "HDD", 3: "nvme"} {
+		stores.SetStore(s.newStore(id, SetStoreLabels([]*metapb.StoreLabel{{Key: DiskKey, Value: disk}})))
+	}
+	stores.SetStore(s.newStore(4))
+
+	c.Assert(stores.GetStore(1).DiskType(), Equals, DiskSSD)
+	c.Assert(stores.GetStore(1).IsSSD(), IsTrue)
+	c.Assert(stores.GetStore(2).DiskType(), Equals, DiskHDD)
+	c.Assert(stores.GetStore(2).IsSSD(), IsFalse)
+	c.Assert(stores.GetStore(3).IsSSD(), IsTrue)
+	c.Assert(stores.GetStore(4).DiskType(), Equals, "")
+	c.Assert(stores.GetStore(4).IsSSD(), IsFalse)
+
+	c.Assert(stores.GetStoresByDiskType("hdd"), HasLen, 1)
+	c.Assert(stores.GetStoresByDiskType(DiskSSD)[0].GetID(), Equals, uint64(1))
+	c.Assert(stores.GetStoresByDiskType(""), HasLen, 1)
+}
+
+func (s *testStoreSuite) TestCanHostRegion(c *C) {
+	const mb = 1 << 20
+	store := s.newStore(1, SetStoreStats(&pdpb.StoreStats{Capacity: 1000 * mb, Available: 300 * mb}))
+	c.Assert(store.CanHostRegion(10, 0.8), IsTrue)
+	// The low space bound is 200MB.
+	c.Assert(store.CanHostRegion(100, 0.8), IsTrue)
+	c.Assert(store.CanHostRegion(101, 0.8), IsFalse)
+
+	// The region is compressed to half of its size.
+	store = s.newStore(2, SetRegionSize(200), SetStoreStats(&pdpb.StoreStats{
+		Capacity:  1000 * mb,
+		Available: 300 * mb,
+		UsedSize:  100 * mb,
+	}))
+	c.Assert(store.CanHostRegion(200, 0.8), IsTrue)
+	c.Assert(store.CanHostRegion(202, 0.8), IsFalse)
+}
+
+func (s *testStoreSuite) TestImbalanceReport(c *C) {
+	stores := NewStoresInfo()
+	stores.SetStore(s.newStore(1, SetLeaderSize(10), SetRegionSize(100)))
+	c.Assert(stores.ImbalanceReport(0.6, 0.8), Equals, ImbalanceReport{})
+
+	stores.SetStore(s.newStore(2, SetLeaderSize(20), SetRegionSize(100)))
+	stores.SetStore(s.newStore(3, SetLeaderSize(60), SetRegionSize(400)))
+	stores.SetStore(s.newStore(4, SetLeaderSize(1000), SetRegionSize(1000), SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.ImbalanceReport(0.6, 0.8), Equals, ImbalanceReport{
+		LeaderSkew:     50.0 / 30,
+		RegionSkew:     300.0 / 200,
+		MaxLeaderStore: 3,
+		MinLeaderStore: 1,
+		MaxRegionStore: 3,
+		MinRegionStore: 1,
+	})
+}
+
+func (s *testStoreSuite) TestTombstoneAge(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+	stores := NewStoresInfo()
+	for id := uint64(1); id <= 3; id++ {
+		stores.SetStore(s.newStore(id))
+	}
+	stores.SetStore(s.newStore(4, SetStoreState(metapb.StoreState_Tombstone)))
+	stores.SetStore(stores.GetStore(1).Clone(SetStoreState(metapb.StoreState_Tombstone)))
+	clock.advance(30 * time.Minute)
+	stores.SetStore(stores.GetStore(2).Clone(SetStoreState(metapb.StoreState_Tombstone)))
+	c.Assert(stores.GetStore(1).TombstonedFor(), Equals, 30*time.Minute)
+	c.Assert(stores.GetStore(2).TombstonedFor(), Equals, time.Duration(0))
+	c.Assert(stores.GetStore(3).TombstonedFor(), Equals, time.Duration(0))
+	// The tombstone time of store 4 is unknown.
+	c.Assert(stores.GetStore(4).TombstonedFor(), Equals, time.Duration(0))
+	c.Assert(stores.GetExpiredTombstones(time.Hour), HasLen, 0)
+
+	clock.advance(2 * time.Hour)
+	c.Assert(stores.GetExpiredTombstones(time.Hour), DeepEquals, []uint64{1, 2})
+	c.Assert(stores.GetExpiredTombstones(2*time.Hour), DeepEquals, []uint64{1})
+}
+
+func (s *testStoreSuite) TestAverageRegionSize(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.ClusterAverageRegionSize(), Equals, int64(0))
+	stores.SetStore(s.newStore(1))
+	c.Assert(stores.GetStore(1).AverageRegionSize(), Equals, int64(0))
+	c.Assert(stores.ClusterAverageRegionSize(), Equals, int64(0))
+
+	stores.SetStore(s.newStore(2, SetRegionCount(10), SetRegionSize(960)))
+	stores.SetStore(s.newStore(3, SetRegionCount(30), SetRegionSize(1000)))
+	stores.SetStore(s.newStore(4, SetRegionCount(1), SetRegionSize(1000), SetStoreState(metapb.StoreState_Offline)))
+	c.Assert(stores.GetStore(2).AverageRegionSize(), Equals, int64(96))
+	c.Assert(stores.GetStore(3).AverageRegionSize(), Equals, int64(33))
+	c.Assert(stores.ClusterAverageRegionSize(), Equals, int64(49))
+}
+
+func (s *testStoreSuite) TestFakeClock(c *C) {
+	clock := newFakeClock()
+	SetClock(clock)
+	defer SetClock(nil)
+
+	store := s.newStore(1, SetLastHeartbeatTS(clock.Now()))
+	c.Assert(store.IsDisconnected(), IsFalse)
+	c.Assert(store.DownTime(), Equals, time.Duration(0))
+	clock.advance(storeDisconnectDuration + time.Second)
+	c.Assert(store.IsDisconnected(), IsTrue)
+	c.Assert(store.IsUnhealth(), IsFalse)
+	clock.advance(storeUnhealthDuration)
+	c.Assert(store.IsUnhealth(), IsTrue)
+
+	SetClock(nil)
+	c.Assert(store.IsDisconnected(), IsFalse)
+}
+
+func (s *testStoreSuite) TestRemoveStoreLabels(c *C) {
+	stores := NewStoresInfo()
+	c.Assert(stores.RemoveStoreLabels(1, []string{"zone"}).Code(), Equals, errcode.NotFoundCode)
+
+	stores.SetStore(s.newStore(1, SetStoreLabels([]*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "hots", Value: "h1"},
+		{Key: "host", Value: "h1"},
+	})))
+	old := stores.GetStore(1)
+	c.Assert(stores.RemoveStoreLabels(1, []string{"HOTS", "rack"}), IsNil)
+	c.Assert(stores.GetStore(1).GetLabels(), DeepEquals, []*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "host", Value: "h1"},
+	})
+	c.Assert(old.GetLabels(), HasLen, 3)
+
+	stores.SetProtectedLabelKeys([]string{"zone"})
+	err := stores.RemoveStoreLabels(1, []string{"host", "Zone"})
+	c.Assert(err.Code(), Equals, StoreLabelInvalidCode)
+	c.Assert(stores.GetStore(1).GetLabels(), HasLen, 2)
+	c.Assert(stores.RemoveStoreLabels(1, []string{"host"}), IsNil)
+	c.Assert(stores.GetStore(1).GetLabelValue("host"), Equals, "")
+}
+
+func (s *testStoreSuite) TestDraining(c *C) {
+	now := time.Now()
+	store := s.newStore(1, SetLastHeartbeatTS(now))
+	other := s.newStore(2, SetLeaderSize(100), SetLastHeartbeatTS(now))
+	c.Assert(store.IsDraining(), IsFalse)
+	c.Assert(store.AllowLeaderTransferIn(), IsTrue)
+	c.Assert(store.BetterLeaderTargetThan(other), IsTrue)
+
+	store = store.Clone(SetDraining(true))
+	c.Assert(store.IsDraining(), IsTrue)
+	c.Assert(store.AllowLeaderTransferIn(), IsFalse)
+	c.Assert(store.AllowAddPeer(), IsTrue)
+	c.Assert(store.BetterLeaderTargetThan(other), IsFalse)
+	c.Assert(store.Clone().IsDraining(), IsTrue)
+
+	store = store.Clone(SetDraining(false))
+	c.Assert(store.AllowLeaderTransferIn(), IsTrue)
+}
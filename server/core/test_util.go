@@ -19,6 +19,7 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pkg/errors"
 )
 
 // SplitRegions split a set of metapb.Region by the middle of regionKey
@@ -94,3 +95,31 @@ func NewMockIDAllocator() *MockIDAllocator {
 func (alloc *MockIDAllocator) Alloc() (uint64, error) {
 	return atomic.AddUint64(&alloc.base, 1), nil
 }
+
+// regionScoreSamples is the number of deltas sampled by
+// AssertRegionScoreMonotonic.
+const regionScoreSamples = 1000
+
+// AssertRegionScoreMonotonic checks that the region score of the store is
+// non-decreasing in the region size within each space stage. It samples the
+// delta from minus the region size to the size which exhausts the available
+// space, and returns an error describing the first decreasing segment.
+func AssertRegionScoreMonotonic(s *StoreInfo, highSpaceRatio, lowSpaceRatio float64) error {
+	amplification := s.ExplainRegionScore(highSpaceRatio, lowSpaceRatio, 0).Amplification
+	lo := -s.GetRegionSize()
+	hi := int64(float64(s.GetAvailable())/(1<<20)*amplification) + 1
+	step := (hi - lo) / regionScoreSamples
+	if step == 0 {
+		step = 1
+	}
+	prev := s.ExplainRegionScore(highSpaceRatio, lowSpaceRatio, lo)
+	for delta := lo + step; delta <= hi; delta += step {
+		e := s.ExplainRegionScore(highSpaceRatio, lowSpaceRatio, delta)
+		if e.Stage == prev.Stage && e.Score < prev.Score {
+			return errors.Errorf("region score of store %d decreases from %v to %v at delta (%d, %d] in %s space stage",
+				s.GetID(), prev.Score, e.Score, delta-step, delta, e.Stage)
+		}
+		prev = e
+	}
+	return nil
+}
@@ -392,12 +392,13 @@ func (f StoreStateFilter) FilterTarget(opt Options, store *core.StoreInfo) bool
 	if f.TransferLeader &&
 		(store.IsDisconnected() ||
 			store.IsBlocked() ||
+			!store.AllowLeaderTransferIn() ||
 			store.GetIsBusy() ||
 			opt.CheckLabelProperty(RejectLeader, store.GetLabels())) {
 		return true
 	}
 
-	if f.MoveRegion && f.filterMoveRegion(opt, store) {
+	if f.MoveRegion && (!store.AllowAddPeer() || f.filterMoveRegion(opt, store)) {
 		return true
 	}
 	return false
@@ -13,6 +13,8 @@
 package schedule
 
 import (
+	"time"
+
 	. "github.com/pingcap/check"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/pd/server/core"
@@ -36,3 +38,24 @@ func (s *testReplicationSuite) TestPendingPeerFilter(c *C) {
 	c.Assert(filter.FilterSource(tc, newStore), IsFalse)
 	c.Assert(filter.FilterTarget(tc, newStore), IsFalse)
 }
+
+func (s *testFiltersSuite) TestStoreStateFilter(c *C) {
+	opt := NewMockSchedulerOptions()
+	tc := NewMockCluster(opt)
+	filter := StoreStateFilter{MoveRegion: true}
+	store := core.NewStoreInfo(&metapb.Store{Id: 1}, core.SetLastHeartbeatTS(time.Now()))
+	c.Assert(filter.FilterSource(tc, store), IsFalse)
+	c.Assert(filter.FilterTarget(tc, store), IsFalse)
+
+	// A read-only store can give away its regions, but cannot take new peers.
+	readOnly := store.Clone(core.SetReadOnly(true))
+	c.Assert(filter.FilterSource(tc, readOnly), IsFalse)
+	c.Assert(filter.FilterTarget(tc, readOnly), IsTrue)
+
+	// A draining store can give away its leaders, but cannot take new ones.
+	filter = StoreStateFilter{TransferLeader: true}
+	c.Assert(filter.FilterTarget(tc, store), IsFalse)
+	draining := store.Clone(core.SetDraining(true))
+	c.Assert(filter.FilterSource(tc, draining), IsFalse)
+	c.Assert(filter.FilterTarget(tc, draining), IsTrue)
+}